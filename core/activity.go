@@ -0,0 +1,77 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// privateChatGroupName 私聊消息在活跃度统计中使用的分组名称
+const privateChatGroupName = "私聊"
+
+// GroupActivityStat 群聊活跃度
+type GroupActivityStat struct {
+	Group         string `json:"group"`
+	Messages      int    `json:"messages"`
+	Replies       int    `json:"replies"`
+	LastMessageAt string `json:"last_message_at,omitempty"`
+
+	lastMessageAt time.Time
+}
+
+// GroupActivity 按群聊统计收到的消息与回复次数, 进程重启后清零
+type GroupActivity struct {
+	groups map[string]*GroupActivityStat
+	mu     sync.Mutex
+}
+
+func NewGroupActivity() *GroupActivity {
+	return &GroupActivity{groups: make(map[string]*GroupActivityStat)}
+}
+
+// RecordMessage 记录收到的消息, 私聊的 groupName 为空
+func (a *GroupActivity) RecordMessage(groupName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stat := a.statOf(groupName)
+	stat.Messages++
+	stat.lastMessageAt = time.Now()
+}
+
+// RecordReply 记录发出的回复
+func (a *GroupActivity) RecordReply(groupName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.statOf(groupName).Replies++
+}
+
+// Snapshot 获取活跃度快照, 按最近消息时间倒序
+func (a *GroupActivity) Snapshot() []GroupActivityStat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := make([]GroupActivityStat, 0, len(a.groups))
+	for _, stat := range a.groups {
+		statCopy := *stat
+		if !stat.lastMessageAt.IsZero() {
+			statCopy.LastMessageAt = stat.lastMessageAt.Format(TimeFormat)
+		}
+		stats = append(stats, statCopy)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].lastMessageAt.After(stats[j].lastMessageAt)
+	})
+	return stats
+}
+
+func (a *GroupActivity) statOf(groupName string) *GroupActivityStat {
+	if groupName == "" {
+		groupName = privateChatGroupName
+	}
+	stat, ok := a.groups[groupName]
+	if !ok {
+		stat = &GroupActivityStat{Group: groupName}
+		a.groups[groupName] = stat
+	}
+	return stat
+}
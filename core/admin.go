@@ -0,0 +1,556 @@
+package core
+
+import (
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminService 管理操作, 聊天中的 admin 命令与HTTP管理接口共用, 保证两者行为一致
+// 修改类操作在这里统一记录审计日志, 任何入口都不会遗漏
+type AdminService struct {
+	instance *BotInstance
+	// group 执行管理操作的群聊, 私聊与HTTP接口为空, 记录到审计日志
+	group string
+	// audited 不为nil时, 写入审计日志后置为true, 聊天命令据此避免重复记录
+	audited *bool
+}
+
+// LoginStatus 微信登录状态
+type LoginStatus struct {
+	State     string `json:"state"`
+	QrcodeUrl string `json:"qrcode_url,omitempty"`
+}
+
+// ConversationSummary 对话概要
+type ConversationSummary struct {
+	Key          string `json:"key"`
+	Messages     int    `json:"messages"`
+	LastActiveAt string `json:"last_active_at,omitempty"`
+}
+
+// AddGroup 添加群聊白名单并保存配置
+func (s AdminService) AddGroup(operator, name string) error {
+	if name == "" {
+		return s.audit(operator, "group add", nil, nil, func() error {
+			return errors.New("群聊名称不能为空")
+		})
+	}
+	return s.audit(operator, "group add", []string{name}, s.groupsState, func() error {
+		return s.updateConf(operator, "group add "+name, func(conf *ChatGptConf) {
+			conf.AddGroupNameWhiteList(name)
+		})
+	})
+}
+
+// RemoveGroup 移除群聊白名单并保存配置
+func (s AdminService) RemoveGroup(operator, name string) error {
+	if name == "" {
+		return s.audit(operator, "group remove", nil, nil, func() error {
+			return errors.New("群聊名称不能为空")
+		})
+	}
+	return s.audit(operator, "group remove", []string{name}, s.groupsState, func() error {
+		return s.updateConf(operator, "group remove "+name, func(conf *ChatGptConf) {
+			conf.RemoveGroupNameWhiteList(name)
+		})
+	})
+}
+
+// groupsState 群聊白名单, 作为审计日志中修改前后的值
+func (s AdminService) groupsState() string {
+	return "[" + strings.Join(s.ListGroups(), ",") + "]"
+}
+
+// ListGroups 获取群聊白名单
+func (s AdminService) ListGroups() []string {
+	return s.instance.confHelper.GetConf().GroupNameWhiteList
+}
+
+// SetPrompt 设置默认提示并保存配置
+func (s AdminService) SetPrompt(operator, prompt string) error {
+	return s.audit(operator, "prompt set", nil, s.GetPrompt, func() error {
+		if prompt == "" {
+			return errors.New("提示不能为空")
+		}
+		return s.updateConf(operator, "prompt set "+prompt, func(conf *ChatGptConf) {
+			conf.SetDefaultPrompt(prompt)
+		})
+	})
+}
+
+// GetPrompt 获取默认提示
+func (s AdminService) GetPrompt() string {
+	return s.instance.confHelper.GetConf().GetDefaultPrompt().Content
+}
+
+// ListConversations 列出所有对话
+func (s AdminService) ListConversations() []ConversationSummary {
+	return s.instance.handler.chatContext.Summaries()
+}
+
+// GetConversation 获取对话内容
+func (s AdminService) GetConversation(key string) ChatCompletionMessages {
+	return s.instance.handler.chatContext.GetTimestampMessages(key)
+}
+
+// ClearConversation 清除对话
+func (s AdminService) ClearConversation(operator, key string) {
+	state := func() string {
+		return fmt.Sprintf("%d messages", len(s.GetConversation(key)))
+	}
+	s.audit(operator, "context clear", []string{key}, state, func() error {
+		s.instance.handler.chatContext.Clear(key)
+		return nil
+	})
+}
+
+// ClearAllConversations 清除所有对话
+func (s AdminService) ClearAllConversations(operator string) {
+	state := func() string {
+		return fmt.Sprintf("%d conversations", len(s.ListConversations()))
+	}
+	s.audit(operator, "context clearall", nil, state, func() error {
+		s.instance.handler.chatContext.ClearAll()
+		return nil
+	})
+}
+
+// Usage 获取模型用量
+func (s AdminService) Usage() UsageSnapshot {
+	return s.instance.handler.usage.Snapshot()
+}
+
+// Activity 获取各群聊的活跃度
+func (s AdminService) Activity() []GroupActivityStat {
+	return s.instance.handler.activity.Snapshot()
+}
+
+// LoginStatus 获取微信登录状态, 等待扫码时包含登录二维码地址
+func (s AdminService) LoginStatus() LoginStatus {
+	status := LoginStatus{State: s.instance.loginState()}
+	if qrcodeUrl := s.instance.loginQrcodeUrl.Load(); qrcodeUrl != nil && status.State != loginStateOnline {
+		status.QrcodeUrl = *qrcodeUrl
+	}
+	return status
+}
+
+// Logout 主动退出微信登录, 退出后该账号不再自动重新登录
+func (s AdminService) Logout(operator string) error {
+	return s.audit(operator, "logout", nil, nil, s.instance.logout)
+}
+
+// KeysStatus 获取API密钥状态
+func (s AdminService) KeysStatus() string {
+	return s.instance.handler.backends.Status()
+}
+
+// ReloadConfig 重新加载配置文件
+func (s AdminService) ReloadConfig(operator string) error {
+	return s.audit(operator, "reload", nil, nil, func() error {
+		if _, err := s.instance.confHelper.LoadConf(); err != nil {
+			metricErrors.WithLabelValues(s.instance.Name, errorCategoryConfig).Inc()
+			return err
+		}
+		return nil
+	})
+}
+
+// GetConfig 获取配置, effective 为true时返回合并环境变量后的生效配置, 敏感字段已遮盖
+func (s AdminService) GetConfig(effective bool) ([]byte, error) {
+	if effective {
+		return s.instance.confHelper.EffectiveConf()
+	}
+	return s.instance.confHelper.FileConf()
+}
+
+// ReplaceConfig 使用新的配置内容替换配置文件, 仍为遮盖值的敏感字段保留原值
+func (s AdminService) ReplaceConfig(operator string, data []byte) error {
+	return s.audit(operator, "config replace", nil, s.configState, func() error {
+		return s.instance.confHelper.Replace(data, operator, "config replace")
+	})
+}
+
+// configState 遮盖敏感字段后的配置文件内容, 作为审计日志中修改前后的值
+func (s AdminService) configState() string {
+	data, err := s.GetConfig(false)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ConfigHistory 获取配置历史
+func (s AdminService) ConfigHistory() ([]*ConfHistoryEntry, error) {
+	return s.instance.confHelper.History().List()
+}
+
+// DiffConfig 比较历史版本与当前配置
+func (s AdminService) DiffConfig(version int) (string, error) {
+	return s.instance.confHelper.DiffHistory(version)
+}
+
+// RollbackConfig 回滚到历史版本
+func (s AdminService) RollbackConfig(operator string, version int) error {
+	return s.audit(operator, "config rollback", []string{fmt.Sprintf("v%d", version)}, s.configState, func() error {
+		_, err := s.instance.confHelper.Rollback(version, operator)
+		return err
+	})
+}
+
+// LogLevels 获取根日志与各组件的日志级别, 日志级别对所有机器人实例生效
+func (s AdminService) LogLevels() map[string]string {
+	return LogLevels()
+}
+
+// SetLogLevel 调整日志级别, component 为组件名称、root 或 all
+func (s AdminService) SetLogLevel(operator, component, level string) error {
+	state := func() string {
+		return strings.ReplaceAll(formatLogLevels(s.LogLevels()), "\n", ", ")
+	}
+	return s.audit(operator, "log level", []string{component, level}, state, func() error {
+		if err := SetLogLevel(component, level); err != nil {
+			return err
+		}
+		s.instance.componentLogger(LogComponentAdmin).Info("管理员调整日志级别", zap.String("operator", operator),
+			zap.String("component", component), zap.String("level", level))
+		return nil
+	})
+}
+
+// audit 执行修改类管理操作并记录审计日志, state 不为nil时在执行前后各取一次作为修改前后的值
+func (s AdminService) audit(operator, command string, args []string, state func() string, run func() error) error {
+	entry := &AdminAuditEntry{
+		Time:    time.Now(),
+		Bot:     s.instance.Name,
+		Actor:   operator,
+		Group:   s.group,
+		Command: command,
+		Args:    args,
+		Result:  AdminAuditResultOk,
+	}
+	if state != nil {
+		entry.Before = state()
+	}
+	err := run()
+	if state != nil {
+		entry.After = state()
+	}
+	if err != nil {
+		entry.Result, entry.Error = AdminAuditResultError, err.Error()
+	}
+	s.RecordAudit(entry)
+	return err
+}
+
+// updateConf 修改并保存配置
+func (s AdminService) updateConf(operator, action string, modify func(conf *ChatGptConf)) error {
+	logger := s.instance.componentLogger(LogComponentAdmin)
+	if _, err := s.instance.confHelper.UpdateAndSave(modify, operator, action); err != nil {
+		logger.Error("保存配置失败: " + err.Error())
+		return errors.WithMessage(err, "update config failed")
+	}
+	logger.Info("管理员修改配置", zap.String("operator", operator), zap.String("action", action))
+	return nil
+}
+
+// errUnknownAdminCommand 不支持的管理员命令, 不回复但仍记录审计日志
+var errUnknownAdminCommand = errors.New("unknown admin command")
+
+// handleAdminCommand 处理管理员命令, 返回回复的消息、命令本身的错误与发送回复的错误
+// 修改类命令由 AdminService 记录审计日志, 其余命令(包括只读、格式错误、不支持和被拒绝的命令)在这里记录
+func (h MessageHandler) handleAdminCommand(msg *openwechat.Message, msgContent string, senderName string,
+) (sent *openwechat.SentMessage, commandErr error, err error) {
+	audited := false
+	admin := h.adminFor(msg, &audited)
+	entry := &AdminAuditEntry{
+		Time:  time.Now(),
+		Bot:   h.instance.Name,
+		Actor: senderName,
+		Group: admin.group,
+	}
+	if !h.isAdmin(msg) {
+		h.instance.componentLogger(LogComponentAdmin).Warn("拒绝非管理员的管理命令", logSender(senderName), logBody("command", msgContent))
+		entry.Command, entry.Result, entry.Error = msgContent, AdminAuditResultError, errAdminForbidden.Error()
+		admin.RecordAudit(entry)
+		sent, err = msg.ReplyText(errAdminForbidden.Error())
+		return sent, errAdminForbidden, err
+	}
+	tokens := strings.Fields(msgContent)
+	reply, commandErr := h.runAdminCommand(admin, tokens, senderName)
+	if !audited {
+		if len(tokens) > 3 {
+			entry.Command, entry.Args = strings.Join(tokens[1:3], " "), tokens[3:]
+		} else {
+			entry.Command = strings.Join(tokens[1:], " ")
+		}
+		entry.Result = AdminAuditResultOk
+		if commandErr != nil {
+			entry.Result, entry.Error = AdminAuditResultError, commandErr.Error()
+		}
+		admin.RecordAudit(entry)
+	}
+
+	if errors.Is(commandErr, errUnknownAdminCommand) {
+		return nil, commandErr, nil
+	}
+	if commandErr != nil {
+		sent, err = msg.ReplyText(commandErr.Error())
+		return sent, commandErr, err
+	}
+	sent, err = msg.ReplyText(reply)
+	return sent, nil, err
+}
+
+// errAdminForbidden 发送者不是管理员
+var errAdminForbidden = errors.New("无管理权限")
+
+// isAdmin 判断消息发送者是否可以执行管理命令: 机器人自己的账号, 或者备注/昵称与 owner、admins 匹配的好友
+// 群聊中按发送者找到对应的好友后再匹配, 非好友的群成员即使昵称相同也不能执行管理命令
+func (h MessageHandler) isAdmin(msg *openwechat.Message) bool {
+	if msg.IsSendBySelf() {
+		return true
+	}
+	conf := h.confHelper.GetConf()
+	if len(conf.AdminNames()) == 0 {
+		return false
+	}
+	var (
+		sender *openwechat.User
+		err    error
+	)
+	if msg.IsComeFromGroup() {
+		sender, err = msg.SenderInGroup()
+	} else {
+		sender, err = msg.Sender()
+	}
+	if err != nil {
+		h.logger.Error("获取管理命令发送者失败: " + err.Error())
+		return false
+	}
+	friends, err := msg.Owner().Friends()
+	if err != nil {
+		h.logger.Error("获取好友列表失败: " + err.Error())
+		return false
+	}
+	return isAdminFriend(conf, friends, sender.UserName)
+}
+
+// isAdminFriend 判断 userName 对应的好友备注或昵称是否与 owner、admins 匹配, 不是好友时返回false
+func isAdminFriend(conf *ChatGptConf, friends openwechat.Friends, userName string) bool {
+	found := friends.SearchByUserName(1, userName)
+	if found.Count() == 0 {
+		return false
+	}
+	friend := found.First()
+	return conf.IsAdmin(friend.RemarkName) || conf.IsAdmin(friend.NickName)
+}
+
+// runAdminCommand 执行管理员命令, 返回回复内容, 命令失败时返回错误
+func (h MessageHandler) runAdminCommand(admin AdminService, tokens []string, senderName string) (string, error) {
+	if len(tokens) >= 2 && tokens[1] == "audit" {
+		return h.runAuditCommand(admin, tokens)
+	}
+	if len(tokens) < 3 {
+		return "", errors.New("admin command format error")
+	}
+	command := tokens[1]
+	subCommand := tokens[2]
+	value := ""
+	if len(tokens) > 3 {
+		value = tokens[3]
+	}
+
+	if command == "group" {
+		if subCommand == "add" {
+			return adminResult(admin.AddGroup(senderName, value), "add group chat prefix success")
+		}
+		if subCommand == "remove" {
+			return adminResult(admin.RemoveGroup(senderName, value), "remove group chat prefix success")
+		}
+		if subCommand == "list" {
+			return strings.Join(admin.ListGroups(), "\n"), nil
+		}
+	}
+	if command == "prompt" {
+		if subCommand == "set" {
+			return adminResult(admin.SetPrompt(senderName, value), "set default prompt success")
+		}
+		if subCommand == "get" {
+			return admin.GetPrompt(), nil
+		}
+	}
+	if command == "context" {
+		if subCommand == "clear" {
+			admin.ClearConversation(senderName, senderName)
+			return "clear context success", nil
+		}
+		if strings.ToLower(subCommand) == "clearall" {
+			admin.ClearAllConversations(senderName)
+			return "clear all context success", nil
+		}
+	}
+	if command == "config" {
+		return h.runConfigCommand(admin, subCommand, value, senderName)
+	}
+	if command == "keys" {
+		if subCommand == "status" {
+			return admin.KeysStatus(), nil
+		}
+	}
+	if command == "usage" {
+		return formatUsage(admin.Usage(), subCommand), nil
+	}
+	if command == "log" && subCommand == "level" {
+		if len(tokens) == 3 {
+			return formatLogLevels(admin.LogLevels()), nil
+		}
+		if len(tokens) != 5 {
+			return "", errors.New("admin command format error: log level <component> <level>")
+		}
+		return adminResult(admin.SetLogLevel(senderName, tokens[3], tokens[4]), "set log level success")
+	}
+	return "", errUnknownAdminCommand
+}
+
+// runConfigCommand 执行配置历史相关的管理员命令
+func (h MessageHandler) runConfigCommand(admin AdminService, subCommand string, value string, senderName string,
+) (string, error) {
+	if subCommand == "history" {
+		entries, err := admin.ConfigHistory()
+		if err != nil {
+			return "", errors.WithMessage(err, "list config history failed")
+		}
+		if len(entries) == 0 {
+			return "no config history", nil
+		}
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			lines = append(lines, entry.String())
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(value, "v"))
+	if err != nil {
+		return "", errors.New("admin command format error: config " + subCommand + " <n>")
+	}
+	if subCommand == "diff" {
+		diff, err := admin.DiffConfig(version)
+		if err != nil {
+			return "", errors.WithMessage(err, "diff config failed")
+		}
+		if diff == "" {
+			return fmt.Sprintf("v%d is identical to current config", version), nil
+		}
+		return diff, nil
+	}
+	if subCommand == "rollback" {
+		if err := admin.RollbackConfig(senderName, version); err != nil {
+			return "", errors.WithMessage(err, "rollback config failed")
+		}
+		return fmt.Sprintf("rollback config to v%d success", version), nil
+	}
+	return "", errUnknownAdminCommand
+}
+
+// runAuditCommand 查看最近的管理员命令, admin audit [n], 默认10条, 最多 maxAdminAuditLimit 条
+func (h MessageHandler) runAuditCommand(admin AdminService, tokens []string) (string, error) {
+	limit := defaultAdminAuditLimit
+	if len(tokens) > 2 {
+		value, err := strconv.Atoi(tokens[2])
+		if err != nil || value <= 0 {
+			return "", errors.New("admin command format error: audit [n]")
+		}
+		limit = value
+	}
+	entries, err := admin.AuditLog(limit)
+	if err != nil {
+		return "", errors.WithMessage(err, "read audit log failed")
+	}
+	if len(entries) == 0 {
+		return "no audit log", nil
+	}
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// adminResult 管理操作的结果, 成功时回复 success
+func adminResult(err error, success string) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return success, nil
+}
+
+// formatLogLevels 按组件名称排序输出日志级别
+func formatLogLevels(levels map[string]string) string {
+	components := make([]string, 0, len(levels))
+	for component := range levels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	lines := make([]string, 0, len(components))
+	for _, component := range components {
+		lines = append(lines, fmt.Sprintf("%s: %s", component, levels[component]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatUsage 格式化用量统计, dimension 可选 sender、group、model, 默认 model
+func formatUsage(usage UsageSnapshot, dimension string) string {
+	stats := usage.ByModel
+	switch dimension {
+	case "sender":
+		stats = usage.BySender
+	case "group":
+		stats = usage.ByGroup
+	}
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{fmt.Sprintf("total requests:%d tokens:%d/%d",
+		usage.Total.Requests, usage.Total.PromptTokens, usage.Total.CompletionTokens)}
+	for _, key := range keys {
+		stat := stats[key]
+		lines = append(lines, fmt.Sprintf("%s requests:%d tokens:%d/%d",
+			key, stat.Requests, stat.PromptTokens, stat.CompletionTokens))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Summaries 获取所有对话的概要, 按最近活跃时间倒序
+func (u *ChatContext) Summaries() []ConversationSummary {
+	u.RLock()
+	defer u.RUnlock()
+
+	summaries := make([]ConversationSummary, 0, len(u.items))
+	lastActive := make(map[string]uint64, len(u.items))
+	for key, messages := range u.items {
+		summary := ConversationSummary{Key: key, Messages: len(messages)}
+		for _, message := range messages {
+			if message.Timestamp > lastActive[key] && message.Role != "system" {
+				lastActive[key] = message.Timestamp
+			}
+		}
+		if lastActive[key] > 0 {
+			summary.LastActiveAt = time.Unix(int64(lastActive[key]), 0).Format(TimeFormat)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return lastActive[summaries[i].Key] > lastActive[summaries[j].Key]
+	})
+	return summaries
+}
@@ -0,0 +1,466 @@
+package core
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminApiPrefix HTTP管理接口的路径前缀
+const adminApiPrefix = "/api/"
+
+// adminApiMaxBodySize 管理接口请求体的最大长度
+const adminApiMaxBodySize = 1 << 20
+
+// adminApiReadAuditInterval 同一操作者重复查看同一接口时记录审计日志的最小间隔, 避免管理页面的定时刷新写满审计日志
+const adminApiReadAuditInterval = time.Hour
+
+// adminApiHandler HTTP管理接口, 与聊天中的 admin 命令共用 AdminService
+// 多账号模式下通过查询参数 bot=<name> 选择机器人实例, 为空时使用第一个实例
+//
+//	GET    /api/bots                         机器人实例列表与登录状态
+//	GET    /api/config[?effective=true]      查看配置, 敏感字段已遮盖
+//	PUT    /api/config                       替换配置, 请求体为配置文件内容
+//	POST   /api/config/reload                重新加载配置文件
+//	GET    /api/config/history               配置历史
+//	GET    /api/config/history/{n}/diff      比较历史版本与当前配置
+//	POST   /api/config/rollback/{n}          回滚到历史版本
+//	GET    /api/groups                       群聊白名单
+//	POST   /api/groups                       添加群聊白名单 {"name": ""}
+//	DELETE /api/groups/{name}                移除群聊白名单
+//	GET    /api/prompt                       默认提示
+//	PUT    /api/prompt                       设置默认提示 {"prompt": ""}
+//	GET    /api/conversations                对话列表
+//	DELETE /api/conversations                清除所有对话
+//	GET    /api/conversations/{key}          对话内容
+//	DELETE /api/conversations/{key}          清除对话
+//	GET    /api/usage                        模型用量
+//	GET    /api/keys                         API密钥状态
+//	GET    /api/activity                     各群聊活跃度
+//	GET    /api/login                        微信登录状态与登录二维码
+//	POST   /api/logout                       主动退出微信登录, 退出后不再自动重新登录
+//	GET    /api/log                          根日志与各组件的日志级别
+//	PUT    /api/log/{component}              调整日志级别 {"level": ""}
+//	GET    /api/audit[?n=10]                 最近的管理员命令审计记录, 最多100条
+//
+// 与聊天中的 admin 命令一致, 通过认证的请求都记录审计日志: 修改类请求由 AdminService 记录,
+// 其余请求(包括查看、格式错误和不支持的请求)在 ServeHTTP 中记录
+type adminApiHandler struct {
+	manager *BotManager
+	admin   AdminService
+}
+
+// BotSummary 机器人实例概要
+type BotSummary struct {
+	Name       string `json:"name"`
+	LoginState string `json:"login_state"`
+}
+
+func (h adminApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	instance, ok := h.manager.Get(r.URL.Query().Get("bot"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "bot not found: "+r.URL.Query().Get("bot"))
+		return
+	}
+	audited := false
+	h.admin = AdminService{instance: instance, audited: &audited}
+	operator := r.Header.Get("X-Operator")
+	if operator == "" {
+		operator = "http"
+	}
+	recorder := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	w = recorder
+	defer func() {
+		if !audited {
+			h.auditRequest(r, operator, recorder)
+		}
+	}()
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminApiPrefix), "/"), "/")
+	for idx, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[idx] = unescaped
+		}
+	}
+
+	switch segments[0] {
+	case "bots":
+		if h.allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, h.bots())
+		}
+	case "config":
+		h.serveConfig(w, r, segments[1:], operator)
+	case "groups":
+		h.serveGroups(w, r, segments[1:], operator)
+	case "prompt":
+		h.servePrompt(w, r, operator)
+	case "conversations":
+		h.serveConversations(w, r, segments[1:], operator)
+	case "usage":
+		if h.allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, h.admin.Usage())
+		}
+	case "keys":
+		if h.allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, map[string]string{"status": h.admin.KeysStatus()})
+		}
+	case "activity":
+		if h.allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, h.admin.Activity())
+		}
+	case "login":
+		if h.allowMethod(w, r, http.MethodGet) {
+			writeJson(w, http.StatusOK, h.admin.LoginStatus())
+		}
+	case "logout":
+		if h.allowMethod(w, r, http.MethodPost) {
+			if err := h.admin.Logout(operator); err != nil {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]string{"result": "logout success"})
+		}
+	case "log":
+		h.serveLog(w, r, segments[1:], operator)
+	case "audit":
+		if h.allowMethod(w, r, http.MethodGet) {
+			h.serveAudit(w, r)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorize 校验 Authorization: Bearer <admin_token>, 未配置 admin_token 时拒绝所有请求
+func (h adminApiHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	conf := h.manager.httpConf()
+	if conf == nil || conf.AdminToken == "" {
+		writeError(w, http.StatusForbidden, "admin api disabled, http.admin_token is not configured")
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(conf.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+// auditRequest 记录没有经过 AdminService 审计的请求, 成功的查看请求在 adminApiReadAuditInterval 内只记录一次
+func (h adminApiHandler) auditRequest(r *http.Request, operator string, recorder *auditResponseWriter) {
+	entry := &AdminAuditEntry{
+		Time:    time.Now(),
+		Bot:     h.admin.instance.Name,
+		Actor:   operator,
+		Command: r.Method + " " + r.URL.Path,
+		Result:  AdminAuditResultOk,
+	}
+	query := r.URL.Query()
+	query.Del("bot")
+	if len(query) > 0 {
+		entry.Args = []string{query.Encode()}
+	}
+	if recorder.status >= http.StatusBadRequest {
+		entry.Result, entry.Error = AdminAuditResultError, recorder.errorMessage()
+	} else if r.Method == http.MethodGet && !adminApiReads.first(entry) {
+		return
+	}
+	h.admin.RecordAudit(entry)
+}
+
+// adminApiReadLog 最近记录过审计日志的查看请求
+type adminApiReadLog struct {
+	recordedAt map[string]time.Time
+	mu         sync.Mutex
+}
+
+var adminApiReads = &adminApiReadLog{recordedAt: make(map[string]time.Time)}
+
+// first 同一机器人、操作者与请求在 adminApiReadAuditInterval 内是否第一次查看, 是时记录查看时间
+func (l *adminApiReadLog) first(entry *AdminAuditEntry) bool {
+	key := strings.Join(append([]string{entry.Bot, entry.Actor, entry.Command}, entry.Args...), "\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for other, at := range l.recordedAt {
+		if entry.Time.Sub(at) >= adminApiReadAuditInterval {
+			delete(l.recordedAt, other)
+		}
+	}
+	if _, ok := l.recordedAt[key]; ok {
+		return false
+	}
+	l.recordedAt[key] = entry.Time
+	return true
+}
+
+// auditResponseWriter 记录管理接口的响应状态码与错误信息, 用于审计日志
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status >= http.StatusBadRequest {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// errorMessage writeError 返回的错误信息
+func (w *auditResponseWriter) errorMessage() string {
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(w.body.Bytes(), &body); err == nil && body.Error != "" {
+		return body.Error
+	}
+	return http.StatusText(w.status)
+}
+
+func (h adminApiHandler) bots() []BotSummary {
+	bots := make([]BotSummary, 0, len(h.manager.Instances()))
+	for _, instance := range h.manager.Instances() {
+		bots = append(bots, BotSummary{Name: instance.Name, LoginState: instance.loginState()})
+	}
+	return bots
+}
+
+func (h adminApiHandler) serveConfig(w http.ResponseWriter, r *http.Request, segments []string, operator string) {
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			data, err := h.admin.GetConfig(r.URL.Query().Get("effective") == "true")
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			data, err := io.ReadAll(io.LimitReader(r.Body, adminApiMaxBodySize))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := h.admin.ReplaceConfig(operator, data); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]string{"result": "replace config success"})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	switch {
+	case segments[0] == "reload" && h.allowMethod(w, r, http.MethodPost):
+		if err := h.admin.ReloadConfig(operator); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"result": "reload success"})
+	case segments[0] == "history" && len(segments) == 1 && h.allowMethod(w, r, http.MethodGet):
+		entries, err := h.admin.ConfigHistory()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// 列表中只返回元数据, 内容差异通过 diff 接口查看
+		for _, entry := range entries {
+			entry.Content = ""
+		}
+		writeJson(w, http.StatusOK, entries)
+	case segments[0] == "history" && len(segments) == 3 && segments[2] == "diff" && h.allowMethod(w, r, http.MethodGet):
+		version, ok := parseVersion(w, segments[1])
+		if !ok {
+			return
+		}
+		diff, err := h.admin.DiffConfig(version)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"diff": diff})
+	case segments[0] == "rollback" && len(segments) == 2 && h.allowMethod(w, r, http.MethodPost):
+		version, ok := parseVersion(w, segments[1])
+		if !ok {
+			return
+		}
+		if err := h.admin.RollbackConfig(operator, version); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"result": fmt.Sprintf("rollback config to v%d success", version)})
+	default:
+		if w.Header().Get("Allow") == "" {
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
+
+func (h adminApiHandler) serveGroups(w http.ResponseWriter, r *http.Request, segments []string, operator string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, h.admin.ListGroups())
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		body := struct {
+			Name string `json:"name"`
+		}{}
+		if !readJson(w, r, &body) {
+			return
+		}
+		if err := h.admin.AddGroup(operator, body.Name); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, h.admin.ListGroups())
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if err := h.admin.RemoveGroup(operator, segments[0]); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, h.admin.ListGroups())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h adminApiHandler) servePrompt(w http.ResponseWriter, r *http.Request, operator string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, map[string]string{"prompt": h.admin.GetPrompt()})
+	case http.MethodPut:
+		body := struct {
+			Prompt string `json:"prompt"`
+		}{}
+		if !readJson(w, r, &body) {
+			return
+		}
+		if err := h.admin.SetPrompt(operator, body.Prompt); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"prompt": h.admin.GetPrompt()})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h adminApiHandler) serveConversations(w http.ResponseWriter, r *http.Request, segments []string,
+	operator string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, h.admin.ListConversations())
+	case len(segments) == 0 && r.Method == http.MethodDelete:
+		h.admin.ClearAllConversations(operator)
+		writeJson(w, http.StatusOK, map[string]string{"result": "clear all context success"})
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		messages := h.admin.GetConversation(segments[0])
+		if messages == nil {
+			writeError(w, http.StatusNotFound, "conversation not found")
+			return
+		}
+		writeJson(w, http.StatusOK, messages)
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		h.admin.ClearConversation(operator, segments[0])
+		writeJson(w, http.StatusOK, map[string]string{"result": "clear context success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h adminApiHandler) serveLog(w http.ResponseWriter, r *http.Request, segments []string, operator string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, h.admin.LogLevels())
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		body := struct {
+			Level string `json:"level"`
+		}{}
+		if !readJson(w, r, &body) {
+			return
+		}
+		if err := h.admin.SetLogLevel(operator, segments[0], body.Level); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, h.admin.LogLevels())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h adminApiHandler) serveAudit(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAdminAuditLimit
+	if value := r.URL.Query().Get("n"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid n: "+value)
+			return
+		}
+		limit = n
+	}
+	entries, err := h.admin.AuditLog(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = make([]*AdminAuditEntry, 0)
+	}
+	writeJson(w, http.StatusOK, entries)
+}
+
+// allowMethod 校验请求方法, 不符合时返回405
+func (h adminApiHandler) allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func parseVersion(w http.ResponseWriter, value string) (int, bool) {
+	version, err := strconv.Atoi(strings.TrimPrefix(value, "v"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid version: "+value)
+		return 0, false
+	}
+	return version, true
+}
+
+func readJson(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, adminApiMaxBodySize)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 审计记录的执行结果
+const (
+	AdminAuditResultOk    = "ok"
+	AdminAuditResultError = "error"
+)
+
+const (
+	// defaultAdminAuditLimit admin audit 默认查看的条数
+	defaultAdminAuditLimit = 10
+	// maxAdminAuditLimit admin audit 与 /api/audit 最多查看的条数
+	maxAdminAuditLimit = 100
+	// adminAuditValueWidth 查看审计日志时修改前后的值最多显示的字数
+	adminAuditValueWidth = 40
+)
+
+// adminAuditMu 多个机器人实例可能共用审计日志文件, 追加写入时互斥
+var adminAuditMu sync.Mutex
+
+// AdminAuditEntry 一条管理员命令的审计记录
+type AdminAuditEntry struct {
+	Time time.Time `json:"time"`
+	Bot  string    `json:"bot"`
+	// Actor 执行者, 与对话上下文使用相同的发送者名称
+	Actor string `json:"actor"`
+	// Group 执行命令的群聊, 私聊时为空
+	Group   string   `json:"group,omitempty"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Before、After 修改类命令执行前后的值, 只读命令为空
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// String 审计记录摘要
+func (e *AdminAuditEntry) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s %s", e.Time.Format(TimeFormat), e.Actor))
+	if e.Group != "" {
+		sb.WriteString(" [" + e.Group + "]")
+	}
+	sb.WriteString(" " + strings.Join(append([]string{e.Command}, e.Args...), " "))
+	sb.WriteString(": " + e.Result)
+	if e.Error != "" {
+		sb.WriteString(" " + e.Error)
+	}
+	if e.Before != e.After {
+		sb.WriteString(fmt.Sprintf(" (%s -> %s)", truncateAuditValue(e.Before), truncateAuditValue(e.After)))
+	}
+	return sb.String()
+}
+
+func truncateAuditValue(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if utf8.RuneCountInString(value) <= adminAuditValueWidth {
+		return value
+	}
+	return string([]rune(value)[:adminAuditValueWidth]) + "..."
+}
+
+// AdminAuditLog 管理员命令审计日志, 每行一个JSON, 只追加不修改
+type AdminAuditLog struct {
+	file string
+}
+
+// NewAdminAuditLog 创建审计日志, file 为空时使用配置文件同级的 <配置文件名>.audit.jsonl
+func NewAdminAuditLog(confFile string, file string) *AdminAuditLog {
+	if file == "" {
+		file = confFile + ".audit.jsonl"
+	}
+	return &AdminAuditLog{file: file}
+}
+
+// Append 追加一条审计记录
+func (l *AdminAuditLog) Append(entry *AdminAuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	adminAuditMu.Lock()
+	defer adminAuditMu.Unlock()
+	f, err := os.OpenFile(l.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "打开审计日志失败")
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Recent 按时间从新到旧获取最近 limit 条记录, bot 不为空时只返回该机器人的记录
+// 格式错误的行(例如写入中断留下的半行)跳过并记录警告, 不影响其他记录
+func (l *AdminAuditLog) Recent(bot string, limit int, logger *zap.Logger) ([]*AdminAuditEntry, error) {
+	data, err := os.ReadFile(l.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "读取审计日志失败")
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	entries := make([]*AdminAuditEntry, 0)
+	for idx := len(lines) - 1; idx >= 0 && len(entries) < limit; idx-- {
+		if strings.TrimSpace(lines[idx]) == "" {
+			continue
+		}
+		entry := &AdminAuditEntry{}
+		if err := json.Unmarshal([]byte(lines[idx]), entry); err != nil {
+			logger.Warn(fmt.Sprintf("跳过审计日志 %s 第 %d 行: %s", l.file, idx+1, err.Error()))
+			continue
+		}
+		if bot != "" && entry.Bot != bot {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// AuditLog 获取管理员命令审计日志
+func (i *ConfHelper) AuditLog() *AdminAuditLog {
+	conf := i.GetConf()
+	if conf == nil {
+		return NewAdminAuditLog(i.file, "")
+	}
+	return NewAdminAuditLog(i.file, conf.AdminAuditFile)
+}
+
+// RecordAudit 记录管理员命令, 写入失败只记录日志, 不影响命令的执行结果
+func (s AdminService) RecordAudit(entry *AdminAuditEntry) {
+	if s.audited != nil {
+		*s.audited = true
+	}
+	if err := s.instance.confHelper.AuditLog().Append(entry); err != nil {
+		s.instance.componentLogger(LogComponentAdmin).Error("写入审计日志失败: " + err.Error())
+	}
+}
+
+// AuditLog 获取当前机器人最近 limit 条管理员命令, 超过 maxAdminAuditLimit 时只返回 maxAdminAuditLimit 条
+func (s AdminService) AuditLog(limit int) ([]*AdminAuditEntry, error) {
+	if limit > maxAdminAuditLimit {
+		limit = maxAdminAuditLimit
+	}
+	return s.instance.confHelper.AuditLog().Recent(s.instance.Name, limit, s.instance.componentLogger(LogComponentAdmin))
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdminServiceAudit 修改类管理操作由 AdminService 记录审计日志, 查看条数不超过 maxAdminAuditLimit
+func TestAdminServiceAudit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"token":"sk-test","character_desc":"v1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewConfHelper(file)
+	if _, err := helper.LoadConf(); err != nil {
+		t.Fatal(err)
+	}
+	audited := false
+	admin := AdminService{instance: &BotInstance{Name: DefaultBotName, confHelper: helper}, group: "测试群",
+		audited: &audited}
+
+	if err := admin.AddGroup("http", "新群"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.AddGroup("http", ""); err == nil {
+		t.Fatal("empty group name should fail")
+	}
+	if err := admin.ReloadConfig("http"); err != nil {
+		t.Fatal(err)
+	}
+	if !audited {
+		t.Fatal("audited flag not set")
+	}
+	entries, err := admin.AuditLog(10)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("entries = %v, %v", entries, err)
+	}
+	reload, failed, added := entries[0], entries[1], entries[2]
+	if reload.Command != "reload" || reload.Result != AdminAuditResultOk {
+		t.Fatalf("reload entry = %+v", reload)
+	}
+	if failed.Command != "group add" || failed.Result != AdminAuditResultError || failed.Error == "" {
+		t.Fatalf("failed entry = %+v", failed)
+	}
+	if added.Actor != "http" || added.Group != "测试群" || added.Before != "[]" || added.After != "[新群]" {
+		t.Fatalf("group add entry = %+v", added)
+	}
+
+	log := helper.AuditLog()
+	for idx := 0; idx < maxAdminAuditLimit+10; idx++ {
+		if err := log.Append(&AdminAuditEntry{Time: time.Now(), Bot: DefaultBotName, Command: "usage"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, err = admin.AuditLog(1 << 30); err != nil || len(entries) != maxAdminAuditLimit {
+		t.Fatalf("len(entries) = %d, %v, want %d", len(entries), err, maxAdminAuditLimit)
+	}
+}
+
+// TestAdminAuditLogSkipsCorruptLines 格式错误的行跳过并记录警告, 其他记录照常返回
+func TestAdminAuditLogSkipsCorruptLines(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	data := `{"bot":"default","command":"usage","result":"ok"}
+not json
+{"bot":"default","command":"group list","result":"ok"}
+{"bot":"default","comm`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	observed, logs := observer.New(zap.WarnLevel)
+	entries, err := NewAdminAuditLog("", file).Recent(DefaultBotName, 10, zap.New(observed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Command != "group list" || entries[1].Command != "usage" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if logs.Len() != 2 {
+		t.Fatalf("warnings = %d, want 2", logs.Len())
+	}
+}
+
+// TestAdminApiAuditsReads HTTP管理接口的查看请求与聊天中的只读命令一样记录审计日志, 重复查看只记录一次
+func TestAdminApiAuditsReads(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"token":"sk-test","character_desc":"v1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewConfHelper(file)
+	if _, err := helper.LoadConf(); err != nil {
+		t.Fatal(err)
+	}
+	instance := &BotInstance{Name: "audit-api", confHelper: helper}
+	server := httptest.NewServer(adminApiHandler{manager: &BotManager{
+		instances: []*BotInstance{instance},
+		httpConf:  func() *HttpConf { return &HttpConf{AdminToken: "admin"} },
+	}})
+	defer server.Close()
+	request := func(method, path, body string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		req.Header.Set("X-Operator", "ops")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	request(http.MethodGet, "/api/groups", "")
+	request(http.MethodGet, "/api/groups", "")
+	request(http.MethodGet, "/api/config/history/abc/diff", "")
+	request(http.MethodPut, "/api/prompt", `{"prompt":"v2"}`)
+
+	entries, err := AdminService{instance: instance}.AuditLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("entries = %+v, want 3", entries)
+	}
+	prompt, invalid, groups := entries[0], entries[1], entries[2]
+	if prompt.Command != "prompt set" || prompt.Actor != "ops" {
+		t.Fatalf("修改请求只由 AdminService 记录一次: %+v", prompt)
+	}
+	if invalid.Command != "GET /api/config/history/abc/diff" || invalid.Result != AdminAuditResultError ||
+		invalid.Error != "invalid version: abc" {
+		t.Fatalf("invalid entry = %+v", invalid)
+	}
+	if groups.Command != "GET /api/groups" || groups.Result != AdminAuditResultOk || groups.Actor != "ops" {
+		t.Fatalf("groups entry = %+v", groups)
+	}
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// DefaultBackendName 默认后端名称, 由顶层的 provider、token、tokens 组成
+const DefaultBackendName = "default"
+
+// BackendConf 模型后端配置, 由提供方与一组密钥组成
+type BackendConf struct {
+	Provider     *ProviderConf `json:"provider,omitempty"`
+	Token        string        `json:"token,omitempty" secret:"true"`
+	TokenFile    string        `json:"token_file,omitempty"`
+	TokenEnv     string        `json:"token_env,omitempty"`
+	Tokens       []*KeyConf    `json:"tokens,omitempty"`
+	KeySelection string        `json:"key_selection,omitempty"`
+	// RequestTimeout 单次模型请求的超时秒数, 超时计为熔断器失败, 默认60秒
+	RequestTimeout int `json:"request_timeout,omitempty"`
+}
+
+// Validate 校验后端配置
+func (b *BackendConf) Validate(name string) error {
+	switch b.KeySelection {
+	case "", KeySelectionRoundRobin, KeySelectionLeastUsed:
+	default:
+		return fmt.Errorf("%s: 不支持的 key_selection: %s", name, b.KeySelection)
+	}
+	if b.RequestTimeout < 0 {
+		return fmt.Errorf("%s: request_timeout 不能小于0", name)
+	}
+	for idx, key := range b.Tokens {
+		if key == nil {
+			return fmt.Errorf("%s: tokens[%d] 不能为空", name, idx)
+		}
+		if err := validateHttpURL(fmt.Sprintf("%s: tokens[%d].base_url", name, idx), key.BaseURL); err != nil {
+			return err
+		}
+	}
+	return errors.WithMessage(b.Provider.Validate(), name)
+}
+
+// FallbackConf 备用模型, backend 为空时使用默认后端
+type FallbackConf struct {
+	Model   string `json:"model"`
+	Backend string `json:"backend,omitempty"`
+}
+
+// ModelProfile 模型配置, 主模型因限流、过载或上下文超长失败时依次尝试备用模型
+type ModelProfile struct {
+	Model     string          `json:"model,omitempty"`
+	Fallbacks []*FallbackConf `json:"fallbacks,omitempty"`
+}
+
+// candidates 按顺序列出需要尝试的模型
+func (p *ModelProfile) candidates() []*FallbackConf {
+	candidates := []*FallbackConf{{Model: p.Model}}
+	return append(candidates, p.Fallbacks...)
+}
+
+// LLMBackends 所有模型后端, 配置中的后端、密钥或熔断配置变化时重新创建并原子替换
+type LLMBackends struct {
+	listener BreakerListener
+	current  atomic.Pointer[backendPools]
+}
+
+// backendPools 按同一份配置创建的所有密钥池
+type backendPools struct {
+	// signature 创建密钥池所用配置的序列化结果, 用于判断配置是否变化
+	signature string
+	pools     map[string]*KeyPool
+}
+
+// NewLLMBackends 根据配置创建默认后端与 backends 中的备用后端, 熔断器状态变化时回调 listener
+func NewLLMBackends(conf *ChatGptConf, listener BreakerListener) (*LLMBackends, error) {
+	backends := &LLMBackends{listener: listener}
+	pools, err := backends.build(conf)
+	if err != nil {
+		return nil, err
+	}
+	backends.current.Store(pools)
+	return backends, nil
+}
+
+// backendSignature 序列化创建密钥池所用的配置, 用于判断配置是否变化
+func backendSignature(conf *ChatGptConf) (string, error) {
+	data, err := json.Marshal([]interface{}{conf.DefaultBackend(), conf.Backends, conf.CircuitBreaker})
+	if err != nil {
+		return "", errors.Wrap(err, "序列化后端配置失败")
+	}
+	return string(data), nil
+}
+
+// build 根据配置创建所有密钥池
+func (b *LLMBackends) build(conf *ChatGptConf) (*backendPools, error) {
+	signature, err := backendSignature(conf)
+	if err != nil {
+		return nil, err
+	}
+	pools := &backendPools{signature: signature, pools: make(map[string]*KeyPool)}
+	defaultPool, err := NewKeyPool(DefaultBackendName, conf.DefaultBackend(), conf.CircuitBreaker, b.listener)
+	if err != nil {
+		return nil, err
+	}
+	pools.pools[DefaultBackendName] = defaultPool
+	for name, backendConf := range conf.Backends {
+		pool, err := NewKeyPool(name, backendConf, conf.CircuitBreaker, b.listener)
+		if err != nil {
+			return nil, errors.WithMessage(err, "backend "+name)
+		}
+		pools.pools[name] = pool
+	}
+	return pools, nil
+}
+
+// prepareReload 作为配置发布钩子, 后端相关配置变化时预先创建新的密钥池, 新配置发布后替换
+// 配置未变化时保留原有密钥池, 不丢失熔断状态与用量统计
+func (b *LLMBackends) prepareReload(conf *ChatGptConf) (func(), error) {
+	signature, err := backendSignature(conf)
+	if err != nil {
+		return nil, err
+	}
+	if signature == b.current.Load().signature {
+		return nil, nil
+	}
+	pools, err := b.build(conf)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		b.current.Store(pools)
+		ComponentLogger(LogComponentLLM).Info("后端配置已变化, 已重新创建模型后端")
+	}, nil
+}
+
+func (b *LLMBackends) pools() map[string]*KeyPool {
+	return b.current.Load().pools
+}
+
+// ChatCompleter 按模型配置请求模型, 返回响应及实际使用的模型
+type ChatCompleter interface {
+	CreateChatCompletion(ctx context.Context, profile *ModelProfile, req openai.ChatCompletionRequest,
+	) (openai.ChatCompletionResponse, string, error)
+}
+
+// CreateChatCompletion 按模型配置依次尝试主模型与备用模型, 返回响应及实际使用的模型
+// 所有候选后端都处于熔断状态时返回 ErrCircuitOpen
+func (b *LLMBackends) CreateChatCompletion(ctx context.Context, profile *ModelProfile,
+	req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, string, error) {
+	var lastErr error
+	allOpen := true
+	for idx, candidate := range profile.candidates() {
+		backendName := candidate.Backend
+		if backendName == "" {
+			backendName = DefaultBackendName
+		}
+		pool, ok := b.pools()[backendName]
+		if !ok {
+			lastErr = fmt.Errorf("后端不存在: %s", backendName)
+			allOpen = false
+			continue
+		}
+
+		req.Model = candidate.Model
+		resp, err := pool.CreateChatCompletion(ctx, req)
+		if err == nil {
+			return resp, candidate.Model, nil
+		}
+		lastErr = errors.WithMessagef(err, "%s/%s", backendName, candidate.Model)
+		allOpen = allOpen && errors.Is(err, ErrCircuitOpen)
+		if !isFallbackError(err) {
+			return resp, candidate.Model, lastErr
+		}
+		if idx < len(profile.Fallbacks) {
+			ComponentLogger(LogComponentLLM).Warn(fmt.Sprintf("模型 %s/%s 请求失败, 尝试备用模型: %s", backendName, candidate.Model, err.Error()))
+		}
+	}
+	if allOpen {
+		lastErr = ErrCircuitOpen
+	}
+	return openai.ChatCompletionResponse{}, "", lastErr
+}
+
+// Reachable 是否至少有一个后端的密钥可用, 只根据本地的剔除与熔断状态判断, 不发起探测请求
+func (b *LLMBackends) Reachable() bool {
+	for _, pool := range b.pools() {
+		if pool.Reachable() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status 所有后端密钥的使用与健康状况
+func (b *LLMBackends) Status() string {
+	pools := b.pools()
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb := bytes.Buffer{}
+	for _, name := range names {
+		sb.WriteString("[" + name + "]\n")
+		sb.WriteString(pools[name].Status())
+	}
+	return sb.String()
+}
+
+// isFallbackError 判断错误是否需要切换到备用模型: 限流、服务过载、上下文超长、密钥全部不可用或熔断
+func isFallbackError(err error) bool {
+	if errors.Is(err, ErrNoAvailableKey) || errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	statusCode, code := apiErrorStatus(err)
+	if code == "context_length_exceeded" {
+		return true
+	}
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
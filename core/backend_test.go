@@ -0,0 +1,45 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLLMBackendsReloadOnPublish(t *testing.T) {
+	helper := NewTestConfHelper()
+	if _, err := helper.Update(func(conf *ChatGptConf) { conf.Token = "sk-first-token-1111" }); err != nil {
+		t.Fatal(err)
+	}
+	backends, err := NewLLMBackends(helper.GetConf(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper.OnPublish(backends.prepareReload)
+
+	before := backends.pools()[DefaultBackendName]
+	if _, err := helper.Update(func(conf *ChatGptConf) { conf.CharacterDesc = "changed" }); err != nil {
+		t.Fatal(err)
+	}
+	if backends.pools()[DefaultBackendName] != before {
+		t.Fatal("后端配置未变化时不应重新创建密钥池")
+	}
+
+	if _, err := helper.Update(func(conf *ChatGptConf) {
+		conf.Backends = map[string]*BackendConf{"backup": {Token: "sk-backup-token-2222"}}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := backends.pools()["backup"]; !ok {
+		t.Fatal("新增的后端未生效")
+	}
+
+	// 钩子返回错误时不发布配置
+	helper.OnPublish(func(conf *ChatGptConf) (func(), error) { return nil, errors.New("rejected") })
+	current := helper.GetConf()
+	if _, err := helper.Update(func(conf *ChatGptConf) { conf.Token = "sk-third-token-3333" }); err == nil {
+		t.Fatal("钩子返回错误时 Update 应失败")
+	}
+	if helper.GetConf() != current || backends.pools()[DefaultBackendName].keys[0].name != "#1 sk-****1111" {
+		t.Fatal("发布失败时配置与后端不应变化")
+	}
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器处于打开状态, 请求被直接拒绝
+var ErrCircuitOpen = errors.New("模型服务熔断中")
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenSeconds      = 60
+	defaultBreakerHalfOpenRequests = 1
+	defaultMaintenanceMessage      = "模型服务暂时不可用, 正在维护中, 请稍后再试"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConf 熔断器配置
+type BreakerConf struct {
+	// FailureThreshold 连续失败多少次后打开熔断器
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// OpenSeconds 熔断器打开后多久进入半开状态
+	OpenSeconds int `json:"open_seconds,omitempty"`
+	// HalfOpenRequests 半开状态下允许同时进行的试探请求数
+	HalfOpenRequests int `json:"half_open_requests,omitempty"`
+}
+
+// BreakerListener 熔断器状态变化回调
+type BreakerListener func(name string, from, to BreakerState)
+
+// CircuitBreaker 熔断器
+// 连续失败达到阈值后打开, 打开期间直接拒绝请求; 超时后进入半开状态放行试探请求,
+// 试探成功则关闭, 失败则重新打开
+type CircuitBreaker struct {
+	name     string
+	conf     BreakerConf
+	listener BreakerListener
+
+	state            BreakerState
+	failures         int
+	openedAt         time.Time
+	halfOpenInFlight int
+	// changes 尚未通知的状态变化, 在释放锁后按顺序通知
+	changes [][2]BreakerState
+	mu      sync.Mutex
+}
+
+// NewCircuitBreaker 创建熔断器, 未配置的参数使用默认值
+func NewCircuitBreaker(name string, conf *BreakerConf, listener BreakerListener) *CircuitBreaker {
+	breakerConf := BreakerConf{}
+	if conf != nil {
+		breakerConf = *conf
+	}
+	if breakerConf.FailureThreshold <= 0 {
+		breakerConf.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if breakerConf.OpenSeconds <= 0 {
+		breakerConf.OpenSeconds = defaultBreakerOpenSeconds
+	}
+	if breakerConf.HalfOpenRequests <= 0 {
+		breakerConf.HalfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+	return &CircuitBreaker{name: name, conf: breakerConf, listener: listener}
+}
+
+// Allow 判断是否放行请求, 放行后必须调用 Success、Failure 或 Release
+func (b *CircuitBreaker) Allow() bool {
+	defer b.emit()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.cooling() {
+			return false
+		}
+		b.transit(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.halfOpenInFlight >= b.conf.HalfOpenRequests {
+			return false
+		}
+		b.halfOpenInFlight++
+	}
+	return true
+}
+
+// Success 记录成功的请求
+func (b *CircuitBreaker) Success() {
+	defer b.emit()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.halfOpenInFlight--
+		b.transit(BreakerClosed)
+	}
+}
+
+// Failure 记录失败的请求
+func (b *CircuitBreaker) Failure() {
+	defer b.emit()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case BreakerHalfOpen:
+		b.halfOpenInFlight--
+		b.transit(BreakerOpen)
+	case BreakerClosed:
+		if b.failures >= b.conf.FailureThreshold {
+			b.transit(BreakerOpen)
+		}
+	}
+}
+
+// Release 放行的请求被调用方取消, 不计入成功或失败, 只归还半开状态下的试探名额
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+}
+
+// State 获取当前状态, 打开后已超过冷却时间时视为半开, 下一个请求会作为试探请求放行
+// 只读取状态, 实际的状态切换仍在 Allow 中进行
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !b.cooling() {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// cooling 打开后是否仍在冷却时间内, 调用方需持有 mu
+func (b *CircuitBreaker) cooling() bool {
+	return time.Since(b.openedAt) < time.Duration(b.conf.OpenSeconds)*time.Second
+}
+
+// transit 切换状态并通知, 调用方需持有 mu
+func (b *CircuitBreaker) transit(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	switch to {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerHalfOpen:
+		b.halfOpenInFlight = 0
+	case BreakerClosed:
+		b.failures = 0
+		b.halfOpenInFlight = 0
+	}
+	b.changes = append(b.changes, [2]BreakerState{from, to})
+}
+
+// emit 在锁外按顺序通知状态变化
+func (b *CircuitBreaker) emit() {
+	b.mu.Lock()
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	if b.listener == nil {
+		return
+	}
+	for _, change := range changes {
+		b.listener(b.name, change[0], change[1])
+	}
+}
+
+// isBreakerFailure 判断错误是否说明后端不可用: 网络错误、限流与服务端错误, 请求本身的错误与取消的请求不计入
+func isBreakerFailure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	statusCode, _ := apiErrorStatus(err)
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	changes := make([][2]BreakerState, 0)
+	breaker := NewCircuitBreaker("test", &BreakerConf{FailureThreshold: 2, OpenSeconds: 60, HalfOpenRequests: 1},
+		func(name string, from, to BreakerState) {
+			changes = append(changes, [2]BreakerState{from, to})
+		})
+
+	// 连续失败达到阈值后打开
+	for i := 0; i < 2; i++ {
+		if !breaker.Allow() {
+			t.Fatalf("第%d次请求应放行", i+1)
+		}
+		breaker.Failure()
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open", breaker.State())
+	}
+	if breaker.Allow() {
+		t.Fatal("打开期间应拒绝请求")
+	}
+
+	// 超时后进入半开状态, 只放行一个试探请求
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-61 * time.Second)
+	breaker.mu.Unlock()
+	if !breaker.Allow() {
+		t.Fatal("超时后应放行试探请求")
+	}
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", breaker.State())
+	}
+	if breaker.Allow() {
+		t.Fatal("半开状态下试探请求数已满, 应拒绝")
+	}
+
+	// 试探失败重新打开, 再次超时后试探成功则关闭
+	breaker.Failure()
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open", breaker.State())
+	}
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-61 * time.Second)
+	breaker.mu.Unlock()
+	if !breaker.Allow() {
+		t.Fatal("超时后应放行试探请求")
+	}
+	breaker.Success()
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("state = %s, want closed", breaker.State())
+	}
+
+	want := [][2]BreakerState{
+		{BreakerClosed, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerClosed},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for idx := range want {
+		if changes[idx] != want[idx] {
+			t.Fatalf("changes[%d] = %v, want %v", idx, changes[idx], want[idx])
+		}
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	breaker := NewCircuitBreaker("test", &BreakerConf{FailureThreshold: 2}, nil)
+	breaker.Allow()
+	breaker.Failure()
+	breaker.Allow()
+	breaker.Success()
+	breaker.Allow()
+	breaker.Failure()
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("非连续失败不应打开熔断器, state = %s", breaker.State())
+	}
+}
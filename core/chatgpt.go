@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sashabaranov/go-openai"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
 	"strings"
 	"sync"
@@ -23,66 +24,130 @@ var ChatGPTCommand = &cobra.Command{
 }
 
 var (
-	handler    MessageHandler
-	confHelper *ConfHelper
 	configFile string
+	botsFile   string
 	logFile    string
 	logLevel   string
+	logFormat  string
+	logRedact  bool
 )
 
 func init() {
 	ChatGPTCommand.PersistentFlags().StringVarP(&configFile, "configFile", "c", "chatgpt.json", "-c chatgpt.json")
+	ChatGPTCommand.PersistentFlags().StringVarP(&botsFile, "botsFile", "b", "", "-b bots.json, 多账号配置, 设置后忽略 -c")
 	ChatGPTCommand.PersistentFlags().StringVarP(&logFile, "logFile", "l", "../log/chatgpt-bot.log", "-l ../log/chatgpt-bot.log")
 	ChatGPTCommand.PersistentFlags().StringVarP(&logLevel, "logLevel", "e", "info", "-e info")
+	ChatGPTCommand.PersistentFlags().StringVarP(&logFormat, "logFormat", "t", LogFormatConsole, "-t json, 日志格式 console 或 json")
+	ChatGPTCommand.PersistentFlags().BoolVarP(&logRedact, "logRedact", "r", false, "-r, 日志脱敏: 发送者记录为哈希值, 消息内容只记录长度")
 }
 
 func processMessage(cmd *cobra.Command, args []string) {
-	InitLogger(logLevel,logFile)
+	InitLogger(LogOptions{Level: logLevel, File: logFile, Format: logFormat, Redact: logRedact})
 
-	initConfHelper()
+	manager, err := buildBotManager()
+	if err != nil {
+		Logger.Panic(err.Error())
+	}
+	startHttpServer(manager)
 
-	buildOpenAIService()
-	bot := buildWechatBotService()
+	manager.Run()
+}
 
-	serve(bot)
+// buildBotManager 设置了多账号配置时启动多个机器人, 否则使用单个配置文件
+func buildBotManager() (*BotManager, error) {
+	if botsFile != "" {
+		return NewMultiBotManager(botsFile)
+	}
+	return NewSingleBotManager(configFile)
 }
 
-func messageHandler(msg *openwechat.Message) {
+// handleMessage 处理收到的微信消息, 注册为 openwechat.Bot 的消息回调
+func (h MessageHandler) handleMessage(msg *openwechat.Message) {
 	var replyErr error
 
+	metricQueueDepth.WithLabelValues(h.instance.Name).Inc()
+	defer metricQueueDepth.WithLabelValues(h.instance.Name).Dec()
+	groupName := h.GetGroupName(msg)
+	metricMessagesReceived.WithLabelValues(h.instance.Name, msg.MsgType.String(), metricGroupLabel(h.confHelper.GetConf(), groupName)).Inc()
+	h.activity.RecordMessage(groupName)
+
+	record := &EventRecord{
+		Time:    time.Now(),
+		Bot:     h.instance.Name,
+		MsgId:   msg.MsgId,
+		MsgType: msg.MsgType.String(),
+		Group:   groupName,
+		Content: msg.Content,
+	}
+	// 获取发送者可能需要请求微信, 只在需要时获取
+	if h.instance.eventsEnabled() || h.instance.eventLogEnabled() {
+		record.Sender = h.GetSenderName(msg)
+	}
+	defer func() {
+		record.DurationMs = time.Since(record.Time).Milliseconds()
+		if replyErr != nil {
+			record.Error = replyErr.Error()
+		}
+		h.instance.writeEvent(record)
+	}()
+	h.instance.emitEvent(WebhookEventMessageReceived, &WebhookMessageData{
+		MsgId:   msg.MsgId,
+		Type:    msg.MsgType.String(),
+		Sender:  record.Sender,
+		Group:   groupName,
+		Content: msg.Content,
+	})
+
 	switch msg.MsgType {
 	case openwechat.MsgTypeText:
-		match, message, err := confHelper.MatchGroupFilter(msg)
+		match, message, err := h.confHelper.MatchGroupFilter(h.instance.Name, msg)
 		if err != nil {
-			Logger.Error(fmt.Sprintf("匹配群聊过滤规则失败: %s, err:%s", message, err.Error()))
+			metricErrors.WithLabelValues(h.instance.Name, errorCategoryFilter).Inc()
+			h.logger.Error("匹配群聊过滤规则失败", zap.String("reason", message), zap.Error(err))
+			h.instance.emitEvent(WebhookEventError, &WebhookErrorData{MsgId: msg.MsgId, Group: groupName, Error: err.Error()})
+			record.Error = err.Error()
 			return
 		}
 		if !match {
-			Logger.Debug(fmt.Sprintf("匹配群聊过滤规则失败: %s", message))
+			h.logger.Debug("匹配群聊过滤规则失败", zap.String("reason", message), zap.String("group", groupName))
+			record.Filtered = true
 			return
 		}
 
-		_, replyErr = handler.replyText(msg)
+		var sent *openwechat.SentMessage
+		sent, replyErr = h.replyText(msg, record)
+		if sent != nil && sent.SendMessage != nil {
+			record.Reply = sent.Content
+		}
 	case openwechat.MsgTypeSys:
-		_, replyErr = handler.replySys(msg)
+		_, replyErr = h.replySys(msg)
 	case 51:
 		replyErr = nil
 	default:
 		replyErr = errors.New("暂不支持该类型消息, " + msg.MsgType.String())
 	}
 	if replyErr != nil {
-		Logger.Warn("处理消息失败: " + replyErr.Error())
+		metricErrors.WithLabelValues(h.instance.Name, errorCategoryReply).Inc()
+		h.logger.Warn("处理消息失败: " + replyErr.Error())
+		h.instance.emitEvent(WebhookEventError, &WebhookErrorData{
+			MsgId:  msg.MsgId,
+			Sender: record.Sender,
+			Group:  groupName,
+			Error:  replyErr.Error(),
+		})
 	}
 }
 
-func (h MessageHandler) replyText(msg *openwechat.Message) (*openwechat.SentMessage, error) {
+// replyText 回复文本消息, 模型请求、结果与耗时记录到 record
+func (h MessageHandler) replyText(msg *openwechat.Message, record *EventRecord) (*openwechat.SentMessage, error) {
 	msgContent := msg.Content
 	isGroupMessage := msg.IsComeFromGroup()
 
 	senderName := h.GetSenderName(msg)
 	msgContent = h.extractMsgContent(isGroupMessage, msgContent)
 
-	Logger.Info(fmt.Sprintf("Receive: %s, %s", senderName, msgContent))
+	h.logger.Info("Receive", logSender(senderName), zap.String("group", h.GetGroupName(msg)),
+		zap.String("msg_type", msg.MsgType.String()), logBody("content", msgContent))
 
 	if msgContent == "ping" {
 		return msg.ReplyText("pong")
@@ -90,36 +155,102 @@ func (h MessageHandler) replyText(msg *openwechat.Message) (*openwechat.SentMess
 		messages := h.chatContext.GetString(senderName)
 		return msg.ReplyText(messages)
 	} else if msgContent == "reload" {
-		if _, err := confHelper.LoadJsonConf(); err != nil {
-			Logger.Error(err.Error())
+		admin := h.adminFor(msg, nil)
+		if !h.isAdmin(msg) {
+			admin.RecordAudit(&AdminAuditEntry{
+				Time:    time.Now(),
+				Bot:     h.instance.Name,
+				Actor:   senderName,
+				Group:   admin.group,
+				Command: msgContent,
+				Result:  AdminAuditResultError,
+				Error:   errAdminForbidden.Error(),
+			})
+			return msg.ReplyText(errAdminForbidden.Error())
+		}
+		if err := admin.ReloadConfig(senderName); err != nil {
+			h.logger.Error(err.Error())
+			return msg.ReplyText("reload failed: " + err.Error())
 		}
 		return msg.ReplyText("reload success")
 	} else if strings.HasPrefix(msgContent, "admin") {
-		_, adminErr := h.handleAdminCommand(msg, msgContent, senderName)
-		return nil, errors.WithMessage(adminErr, "admin command error")
+		handle := h.handleAdminCommand
+		if msgContent == "admin export" || strings.HasPrefix(msgContent, "admin export ") {
+			handle = h.handleExportCommand
+		}
+		_, commandErr, err := handle(msg, msgContent, senderName)
+		event := &WebhookAdminData{Sender: senderName, Group: h.GetGroupName(msg), Command: msgContent}
+		if commandErr != nil {
+			event.Error = commandErr.Error()
+		}
+		h.instance.emitEvent(WebhookEventAdminCommand, event)
+		return nil, errors.WithMessage(err, "admin command error")
+	}
+
+	groupName := h.GetGroupName(msg)
+	responseBody, model, usage, err := h.completeText(msg.MsgType.String(), senderName, groupName, msgContent, record,
+		time.Now())
+	if errors.Is(err, ErrCircuitOpen) {
+		return msg.ReplyText(h.formatChatGPTResponse(msg, h.confHelper.GetConf().GetMaintenanceMessage()))
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "openai api error")
 	}
 
+	responseText := h.formatChatGPTResponse(msg, responseBody)
+	if h.confHelper.GetConf().ShowModel {
+		responseText += fmt.Sprintf("\n[%s]", model)
+	}
+
+	sent, err := msg.ReplyText(responseText)
+	if err == nil {
+		h.activity.RecordReply(groupName)
+		h.instance.emitEvent(WebhookEventReplySent, &WebhookReplyData{
+			MsgId:            msg.MsgId,
+			Sender:           senderName,
+			Group:            groupName,
+			Model:            model,
+			Request:          msgContent,
+			Reply:            responseBody,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+		})
+	}
+	return sent, err
+}
+
+// completeText 把文本消息加入发送者的对话上下文并请求模型, 返回模型回复与实际使用的模型
+// 微信消息与回放共用, 模型请求、结果与耗时记录到 record; now 为消息时间, 决定对话上下文的过期
+// 所有候选后端都处于熔断状态时返回 ErrCircuitOpen
+func (h MessageHandler) completeText(msgType, senderName, groupName, msgContent string, record *EventRecord,
+	now time.Time) (string, string, openai.Usage, error) {
 	newMessage := h.buildChatGPTRequestMessage(msgContent)
 	h.chatContext.SetDefaultMessage(senderName)
-	h.chatContext.AppendMessage(senderName, newMessage)
+	h.chatContext.AppendMessage(senderName, newMessage, now)
 
-	messages := h.chatContext.GetMessages(senderName)
-	completionReq := h.buildCompletionRequest(messages)
+	messages := h.chatContext.GetMessages(senderName, now)
+	profile := h.confHelper.GetConf().GetModelProfile(groupName)
+	completionReq := h.buildCompletionRequest(profile, messages)
+	record.Request = &completionReq
 
-	resp, err := h.openapiClient.CreateChatCompletion(context.Background(), completionReq)
+	start := time.Now()
+	resp, model, err := h.completer.CreateChatCompletion(context.Background(), profile, completionReq)
+	latency := time.Since(start)
+	record.LatencyMs = latency.Milliseconds()
 	if err != nil {
-		return nil, errors.WithMessage(err, "openai api error")
+		metricErrors.WithLabelValues(h.instance.Name, errorCategoryLLM).Inc()
+		return "", model, openai.Usage{}, err
 	}
+	h.usage.Record(senderName, groupName, model, resp.Usage)
+	record.Response, record.Model = &resp, model
 
 	responseBody := h.extractChatGPTResponseBody(resp)
-	responseText := h.formatChatGPTResponse(msg, responseBody)
-
 	assistanceMessage := h.buildChatGPTAssistantContextMessage(responseBody)
-	h.chatContext.AppendMessage(senderName, &assistanceMessage)
+	h.chatContext.AppendMessage(senderName, &assistanceMessage, now)
 
-	logInOutMessage(senderName, msgContent, responseBody, h.chatContext.GetTimestampMessages(senderName))
-
-	return msg.ReplyText(responseText)
+	h.logInOutMessage(msgType, senderName, groupName, model, latency, resp.Usage, msgContent, responseBody,
+		h.chatContext.GetTimestampMessages(senderName))
+	return responseBody, model, resp.Usage, nil
 }
 
 func (h MessageHandler) buildChatGPTRequestMessage(msgContent string) *ChatCompletionMessage {
@@ -155,11 +286,12 @@ func (h MessageHandler) extractChatGPTResponseBody(resp openai.ChatCompletionRes
 	return rspContent
 }
 
-func (h MessageHandler) buildCompletionRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+func (h MessageHandler) buildCompletionRequest(profile *ModelProfile, messages []openai.ChatCompletionMessage,
+) openai.ChatCompletionRequest {
 	completionReq := openai.ChatCompletionRequest{
-		Model:            openai.GPT3Dot5Turbo,
+		Model:            profile.Model,
 		Messages:         messages,
-		MaxTokens:        confHelper.GetConf().ConversationMaxTokens,
+		MaxTokens:        h.confHelper.GetConf().ConversationMaxTokens,
 		Temperature:      0.9,
 		FrequencyPenalty: 1,
 		TopP:             1,
@@ -170,7 +302,7 @@ func (h MessageHandler) buildCompletionRequest(messages []openai.ChatCompletionM
 
 func (h MessageHandler) extractMsgContent(isGroupMessage bool, msgContent string) string {
 	if isGroupMessage {
-		for _, prefix := range confHelper.GetConf().GroupChatPrefix {
+		for _, prefix := range h.confHelper.GetConf().GroupChatPrefix {
 			if strings.HasPrefix(msgContent, prefix) {
 				// remove prefix
 				msgContent = strings.TrimPrefix(msgContent, prefix)
@@ -182,104 +314,97 @@ func (h MessageHandler) extractMsgContent(isGroupMessage bool, msgContent string
 	return msgContent
 }
 
-func logInOutMessage(senderName string, req string, rsp string, contexts ChatCompletionMessages) {
-	sb := bytes.Buffer{}
-	sb.WriteString("\nsenderName:")
-	sb.WriteString(senderName)
-	sb.WriteString("\n")
-	sb.WriteString("req:")
-	sb.WriteString(req)
-	sb.WriteString("\n")
-	sb.WriteString("rsp:")
-	sb.WriteString(rsp)
-	sb.WriteString("\n")
-	sb.WriteString("contexts:")
-	sb.WriteString("\n")
-	for _, c := range contexts {
-		sb.WriteString("\t-time:")
-		sb.WriteString("\t" + time.Unix(int64(c.Timestamp), 0).Format("2006-01-02 15:04:05"))
-		sb.WriteString("\t-role:")
-		sb.WriteString("\t" + c.Role)
-		sb.WriteString("\t" + "-content:")
-		sb.WriteString("\t" + c.Content)
-		sb.WriteString("\n")
-	}
-	Logger.Info(sb.String())
-}
-
-func buildWechatBotService() *openwechat.Bot {
+// logInOutMessage 记录一次问答, 开启日志脱敏时发送者为哈希值, 消息内容只记录长度
+func (h MessageHandler) logInOutMessage(msgType, senderName, groupName, model string, latency time.Duration,
+	usage openai.Usage, req, rsp string, contexts ChatCompletionMessages) {
+	h.logger.Info("Reply",
+		logSender(senderName),
+		zap.String("group", groupName),
+		zap.String("msg_type", msgType),
+		zap.String("model", model),
+		zap.Duration("latency", latency),
+		zap.Int("prompt_tokens", usage.PromptTokens),
+		zap.Int("completion_tokens", usage.CompletionTokens),
+		logBody("req", req),
+		logBody("rsp", rsp),
+		zap.Array("contexts", logContexts(contexts)),
+	)
+}
+
+// buildWechatBotService 创建机器人并登录, 优先使用保存的热登录信息, 失效时扫码登录
+func (b *BotInstance) buildWechatBotService(reloadStorage openwechat.HotReloadStorage) (*openwechat.Bot, error) {
 	bot := openwechat.DefaultBot(openwechat.Desktop) // 桌面模式
-	reloadStorage := openwechat.NewFileHotReloadStorage(".login.storage.json")
-	defer reloadStorage.Close()
 
-	bot.SyncCheckCallback = func(resp openwechat.SyncCheckResponse) {} // 忽略回调输出
+	bot.SyncCheckCallback = b.recordSyncCheck // 记录同步时间, 供健康检查使用
 
 	// 注册消息处理函数
-	bot.MessageHandler = messageHandler // 注册登陆二维码回调
-	bot.UUIDCallback = PrintlnQrcodeUrl
+	bot.MessageHandler = b.handler.handleMessage // 注册登陆二维码回调
+	bot.UUIDCallback = b.PrintlnQrcodeUrl
+	b.bot.Store(bot)
 
 	// 登陆
-	if err := bot.PushLogin(reloadStorage, openwechat.NewRetryLoginOption()); err != nil {
-		Logger.Panic(err.Error())
-		return nil
+	if err := bot.HotLogin(reloadStorage, openwechat.NewRetryLoginOption()); err != nil {
+		return nil, err
 	}
-	return bot
+	return bot, nil
 }
 
-func PrintlnQrcodeUrl(uuid string)  {
-	Logger.Info("访问下面网址扫描二维码登录")
+func (b *BotInstance) PrintlnQrcodeUrl(uuid string) {
+	b.logger.Info("访问下面网址扫描二维码登录")
 	qrcodeUrl := openwechat.GetQrcodeUrl(uuid)
-	Logger.Info(qrcodeUrl)
-}
-
-func buildOpenAIService() {
-	client := openai.NewClient(confHelper.GetConf().Token)
-	handler.openapiClient = client
-	handler.chatContext = buildDefaultChatContext()
+	b.logger.Info(qrcodeUrl)
+	b.loginQrcodeUrl.Store(&qrcodeUrl)
+	b.showLoginQrcode(uuid)
 }
 
-func buildDefaultChatContext() *ChatContext {
+func NewChatContext(confHelper *ConfHelper, logger *zap.Logger) *ChatContext {
 	return &ChatContext{
-		items: make(map[string]ChatCompletionMessages),
+		items:      make(map[string]ChatCompletionMessages),
+		confHelper: confHelper,
+		logger:     logger,
 	}
 }
 
-func serve(bot *openwechat.Bot) {
+// serve 阻塞直到微信掉线或退出, 返回掉线原因, 用户主动退出时为nil
+func (b *BotInstance) serve(bot *openwechat.Bot) error {
 	// 获取登陆的用户
 	user, err := bot.GetCurrentUser()
 	if err != nil {
-		Logger.Error(err.Error())
-		return
+		return err
 	}
 
-	Logger.Info("登陆成功, 当前用户: " + user.NickName)
+	b.logger.Info("登陆成功, 当前用户: " + user.NickName)
+	b.everLoggedIn.Store(true)
+	b.reconnecting.Store(false)
+	b.loginQrcodeUrl.Store(nil)
+	b.lastSyncAt.Store(time.Now().Unix())
+	b.emitEvent(WebhookEventLogin, &WebhookLoginData{User: user.NickName})
 
-	// 阻塞主goroutine, 直到发生异常或者用户主动退出
-	bot.Block()
-}
-
-func initConfHelper() {
-	confHelper = NewConfHelper(configFile)
-	if _, err := confHelper.LoadJsonConf(); err != nil {
-		Logger.Panic(err.Error())
-	}
+	// 阻塞goroutine, 直到发生异常或者用户主动退出
+	return bot.Block()
 }
 
+// MessageHandler 处理一个机器人实例收到的消息
 type MessageHandler struct {
-	openapiClient *openai.Client
-	chatContext   *ChatContext
+	instance    *BotInstance
+	confHelper  *ConfHelper
+	logger      *zap.Logger
+	backends    *LLMBackends
+	completer   ChatCompleter
+	chatContext *ChatContext
+	usage       *UsageTracker
+	activity    *GroupActivity
 }
 
-func (h MessageHandler) saveAndLoadConf(msg *openwechat.Message, response string) (*openwechat.SentMessage, error) {
-	confHelper.SaveJsonConf(confHelper.GetConf())
-	confHelper.LoadJsonConf()
-	return msg.ReplyText(response)
+// adminFor 在消息所在的群聊中执行管理操作, audited 不为nil时记录审计日志后置为true
+func (h MessageHandler) adminFor(msg *openwechat.Message, audited *bool) AdminService {
+	return AdminService{instance: h.instance, group: h.GetGroupName(msg), audited: audited}
 }
 
 func (h MessageHandler) fillGroupMessageMentionUser(msg *openwechat.Message, content string) string {
 	user, err := msg.SenderInGroup()
 	if err != nil {
-		Logger.Error("获取群成员信息失败: " + err.Error())
+		h.logger.Error("获取群成员信息失败: " + err.Error())
 		return content
 	}
 	return fmt.Sprintf("@%s %s", user.NickName, content)
@@ -290,19 +415,32 @@ func (h MessageHandler) GetSenderName(msg *openwechat.Message) string {
 	if msg.IsComeFromGroup() {
 		sender, err := msg.SenderInGroup()
 		if err != nil {
-			Logger.Error("获取群成员信息失败: " + err.Error())
+			h.logger.Error("获取群成员信息失败: " + err.Error())
 			return msg.FromUserName
 		}
 		return fmt.Sprintf("Group:%s(%d)", sender.NickName, sender.Uin)
 	}
 	sender, err := msg.Sender()
 	if err != nil {
-		Logger.Error("获取用户信息失败: " + err.Error())
+		h.logger.Error("获取用户信息失败: " + err.Error())
 		return msg.FromUserName
 	}
 	return fmt.Sprintf("Person:%s(%d)", sender.NickName, sender.Uin)
 }
 
+// GetGroupName 获取群聊名称, 非群聊消息返回空字符串
+func (h MessageHandler) GetGroupName(msg *openwechat.Message) string {
+	if !msg.IsComeFromGroup() {
+		return ""
+	}
+	group, err := msg.Sender()
+	if err != nil {
+		h.logger.Error("获取群聊信息失败: " + err.Error())
+		return ""
+	}
+	return group.NickName
+}
+
 // replySys 处理系统消息
 func (h MessageHandler) replySys(msg *openwechat.Message) (*openwechat.SentMessage, error) {
 	isPyp := msg.IsTickledMe()
@@ -323,7 +461,9 @@ func (h MessageHandler) fillPypMessageMentionUser(msg *openwechat.Message, conte
 }
 
 type ChatContext struct {
-	items map[string]ChatCompletionMessages
+	items      map[string]ChatCompletionMessages
+	confHelper *ConfHelper
+	logger     *zap.Logger
 	sync.RWMutex
 }
 
@@ -336,7 +476,7 @@ func (u *ChatContext) SetDefaultMessage(key string) {
 		return
 	}
 	u.items[key] = ChatCompletionMessages{
-		{ChatCompletionMessage: confHelper.GetConf().GetDefaultPrompt(), Timestamp: 0xffffffff},
+		{ChatCompletionMessage: u.confHelper.GetConf().GetDefaultPrompt(), Timestamp: 0xffffffff},
 	}
 	return
 }
@@ -369,18 +509,18 @@ func (c *ChatCompletionMessages) RemoveSecondItem() {
 	*c = append((*c)[:1], (*c)[2:]...)
 }
 
-// FillTimestamp 填充时间戳
-func (c *ChatCompletionMessage) FillTimestamp() {
+// FillTimestamp 以 now 填充时间戳
+func (c *ChatCompletionMessage) FillTimestamp(now time.Time) {
 	if c.Timestamp == 0 {
-		c.Timestamp = uint64(time.Now().Unix())
+		c.Timestamp = uint64(now.Unix())
 	}
 }
 
-// GetValidChatCompletionMessages 获取有效时间范围内的聊天消息
-func (c *ChatCompletionMessages) GetValidChatCompletionMessages() []openai.ChatCompletionMessage {
+// GetValidChatCompletionMessages 获取 now 时仍在有效时间范围内的聊天消息, timeout 为对话超时秒数
+func (c *ChatCompletionMessages) GetValidChatCompletionMessages(timeout int, now time.Time) []openai.ChatCompletionMessage {
 	result := make([]openai.ChatCompletionMessage, 0)
 	for _, v := range *c {
-		if v.IsExpired() {
+		if v.IsExpired(timeout, now) {
 			continue
 		}
 		result = append(result, v.ChatCompletionMessage)
@@ -388,11 +528,11 @@ func (c *ChatCompletionMessages) GetValidChatCompletionMessages() []openai.ChatC
 	return result
 }
 
-// GetValidMessages 获取有效时间范围内的聊天消息
-func (c *ChatCompletionMessages) GetValidMessages() ChatCompletionMessages {
+// GetValidMessages 获取 now 时仍在有效时间范围内的聊天消息, timeout 为对话超时秒数
+func (c *ChatCompletionMessages) GetValidMessages(timeout int, now time.Time) ChatCompletionMessages {
 	result := make([]*ChatCompletionMessage, 0)
 	for _, v := range *c {
-		if v.IsExpired() {
+		if v.IsExpired(timeout, now) {
 			continue
 		}
 		result = append(result, v)
@@ -400,31 +540,33 @@ func (c *ChatCompletionMessages) GetValidMessages() ChatCompletionMessages {
 	return result
 }
 
-// IsExpired 判断消息是否过期
-func (i *ChatCompletionMessage) IsExpired() bool {
-	messageExpireTimestamp := i.Timestamp + uint64(confHelper.ConversationTimeout())
-	currentTimestamp := uint64(time.Now().Unix())
+// IsExpired 判断消息在 now 时是否过期
+func (i *ChatCompletionMessage) IsExpired(timeout int, now time.Time) bool {
+	messageExpireTimestamp := i.Timestamp + uint64(timeout)
+	currentTimestamp := uint64(now.Unix())
 	return messageExpireTimestamp < currentTimestamp
 }
 
-// AppendMessage 追加消息
-func (u *ChatContext) AppendMessage(key string, value *ChatCompletionMessage) {
+// AppendMessage 追加消息, now 为消息时间, 同时丢弃此时已过期的消息
+func (u *ChatContext) AppendMessage(key string, value *ChatCompletionMessage, now time.Time) {
 	u.Lock()
 	defer u.Unlock()
 
 	ms := u.items[key]
 
-	value.FillTimestamp()
-	validMs := ms.GetValidMessages()
+	value.FillTimestamp(now)
+	validMs := ms.GetValidMessages(u.confHelper.ConversationTimeout(), now)
 	validMs = append(validMs, value)
 
 	totalToken := 0
-	maxToken := confHelper.ConversationMaxTokens()
+	maxToken := u.confHelper.ConversationMaxTokens()
 
 	for _, c := range validMs {
 		totalToken += utf8.RuneCountInString(c.Content)
 	}
 	if totalToken > maxToken {
+		u.logger.Debug("对话超过最大长度, 移除最早的一条消息", logSender(key),
+			zap.Int("tokens", totalToken), zap.Int("max_tokens", maxToken))
 		validMs.RemoveSecondItem()
 	}
 	u.items[key] = validMs
@@ -434,6 +576,7 @@ func (u *ChatContext) AppendMessage(key string, value *ChatCompletionMessage) {
 func (u *ChatContext) Clear(key string) {
 	u.Lock()
 	defer u.Unlock()
+	u.logger.Info("清除对话", logSender(key))
 	u.items[key] = make(ChatCompletionMessages, 0)
 }
 
@@ -441,15 +584,33 @@ func (u *ChatContext) Clear(key string) {
 func (u *ChatContext) ClearAll() {
 	u.Lock()
 	defer u.Unlock()
+	u.logger.Info("清除所有对话", zap.Int("count", len(u.items)))
 	u.items = make(map[string]ChatCompletionMessages, 0)
 }
 
-// GetMessages 获取消息
-func (u *ChatContext) GetMessages(senderName string) []openai.ChatCompletionMessage {
+// GetMessages 获取 now 时仍有效的消息
+func (u *ChatContext) GetMessages(senderName string, now time.Time) []openai.ChatCompletionMessage {
 	u.RLock()
 	defer u.RUnlock()
 	val := u.items[senderName]
-	return val.GetValidChatCompletionMessages()
+	return val.GetValidChatCompletionMessages(u.confHelper.ConversationTimeout(), now)
+}
+
+// ActiveCount 获取仍有有效对话内容的会话数, 只剩默认提示的会话不计入
+func (u *ChatContext) ActiveCount() int {
+	u.RLock()
+	defer u.RUnlock()
+	count := 0
+	timeout := u.confHelper.ConversationTimeout()
+	for _, messages := range u.items {
+		for _, message := range messages.GetValidMessages(timeout, time.Now()) {
+			if message.Role != openai.ChatMessageRoleSystem {
+				count++
+				break
+			}
+		}
+	}
+	return count
 }
 
 // GetTimestampMessages 获取消息
@@ -458,49 +619,3 @@ func (u *ChatContext) GetTimestampMessages(senderName string) ChatCompletionMess
 	defer u.RUnlock()
 	return u.items[senderName]
 }
-
-// handleAdminCommand 处理管理员命令
-func (h MessageHandler) handleAdminCommand(msg *openwechat.Message, msgContent string, senderName string,
-) (*openwechat.SentMessage, error) {
-	tokens := strings.Split(msgContent, " ")
-	if len(tokens) < 4 {
-		return msg.ReplyText("admin command format error")
-	}
-	command := tokens[1]
-	subCommand := tokens[2]
-	value := tokens[3]
-
-	if command == "group" {
-		if subCommand == "add" {
-			confHelper.GetConf().AddGroupNameWhiteList(value)
-			return h.saveAndLoadConf(msg, "add group chat prefix success")
-		}
-		if subCommand == "remove" {
-			confHelper.GetConf().RemoveGroupNameWhiteList(value)
-			return h.saveAndLoadConf(msg, "remove group chat prefix success")
-		}
-		if subCommand == "list" {
-			return h.saveAndLoadConf(msg, strings.Join(confHelper.GetConf().GroupNameWhiteList, "\n"))
-		}
-	}
-	if command == "prompt" {
-		if subCommand == "set" {
-			confHelper.GetConf().SetDefaultPrompt(value)
-			return h.saveAndLoadConf(msg, "set default prompt success")
-		}
-		if subCommand == "get" {
-			return h.saveAndLoadConf(msg, confHelper.GetConf().GetDefaultPrompt().Content)
-		}
-	}
-	if command == "context" {
-		if subCommand == "clear" {
-			h.chatContext.Clear(senderName)
-			return h.saveAndLoadConf(msg, "clear context success")
-		}
-		if strings.ToLower(subCommand) == "clearall" {
-			h.chatContext.ClearAll()
-			return h.saveAndLoadConf(msg, "clear all context success")
-		}
-	}
-	return nil, nil
-}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// TestChatContextExpiryFollowsClock 对话上下文的过期以传入的消息时间判断, 回放历史消息时不受当前时间影响
+func TestChatContextExpiryFollowsClock(t *testing.T) {
+	chatContext := NewChatContext(NewTestConfHelper(), zap.NewNop())
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local)
+	key := "Person:test(1)"
+	message := func(content string) *ChatCompletionMessage {
+		return &ChatCompletionMessage{ChatCompletionMessage: openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleUser, Content: content}}
+	}
+	chatContext.SetDefaultMessage(key)
+	chatContext.AppendMessage(key, message("a"), start)
+
+	if messages := chatContext.GetMessages(key, start.Add(10*time.Minute)); len(messages) != 2 {
+		t.Fatalf("messages within timeout = %d, want 2", len(messages))
+	}
+	// 默认超时为3600秒, 两小时后只剩默认提示
+	later := start.Add(2 * time.Hour)
+	chatContext.AppendMessage(key, message("b"), later)
+	messages := chatContext.GetMessages(key, later)
+	if len(messages) != 2 || messages[1].Content != "b" {
+		t.Fatalf("messages after timeout = %+v, want default prompt and b", messages)
+	}
+}
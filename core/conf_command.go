@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+var ConfigCommand = &cobra.Command{
+	Use:   "config",
+	Short: "配置管理",
+	Long:  fmt.Sprintf(""),
+}
+
+var configPrintCommand = &cobra.Command{
+	Use:   "print",
+	Short: "打印配置, 敏感字段已遮盖",
+	Long:  fmt.Sprintf("配置优先级从低到高: 默认值 < 配置文件 < 环境变量(%s*)", EnvPrefix),
+	RunE:  printConf,
+}
+
+var configEncryptCommand = &cobra.Command{
+	Use:   "encrypt",
+	Short: "加密敏感字段, 输出可直接填入配置文件的 enc: 值",
+	Long: fmt.Sprintf("从标准输入读取一行待加密的值, 避免明文出现在命令行参数与shell历史中, "+
+		"例如 ./bin/go-chatgpt-bot config encrypt < token.txt\n密钥从环境变量%s或--secretKeyFile读取", DefaultSecretKeyEnv),
+	Args: cobra.NoArgs,
+	RunE: encryptConfValue,
+}
+
+var (
+	printEffective bool
+	secretKeyFile  string
+)
+
+func init() {
+	ConfigCommand.PersistentFlags().StringVarP(&configFile, "configFile", "c", "chatgpt.json", "-c chatgpt.json")
+	configPrintCommand.Flags().BoolVar(&printEffective, "effective", false, "--effective 打印合并环境变量后的生效配置")
+	configEncryptCommand.Flags().StringVar(&secretKeyFile, "secretKeyFile", "", "--secretKeyFile secret.key")
+	ConfigCommand.AddCommand(configPrintCommand, configEncryptCommand)
+}
+
+func printConf(cmd *cobra.Command, args []string) error {
+	helper := NewConfHelper(configFile)
+	if _, err := helper.LoadConf(); err != nil {
+		return err
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if printEffective {
+		data, err = helper.EffectiveConf()
+	} else {
+		data, err = helper.FileConf()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(data))
+	return nil
+}
+
+func encryptConfValue(cmd *cobra.Command, args []string) error {
+	key, err := loadSecretKey(DefaultSecretKeyEnv, secretKeyFile)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(cmd.ErrOrStderr(), "请输入要加密的值: ")
+	}
+	plaintext, err := readSecretLine(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	value, err := EncryptSecret(key, plaintext)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), value)
+	return nil
+}
+
+// readSecretLine 读取第一行作为待加密的值
+func readSecretLine(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "读取待加密的值失败")
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("待加密的值为空, 请从标准输入提供")
+	}
+	return line, nil
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix 环境变量覆盖配置的前缀
+// 字段对应的环境变量名为前缀加上大写的json标签, 例如 token 对应 CHATGPT_BOT_TOKEN,
+// 嵌套结构以下划线连接, 列表类型使用逗号分隔, 映射类型使用 key=value 并以逗号分隔
+// 元素为结构的列表与映射可以整体以JSON设置, 例如 CHATGPT_BOT_TOKENS='[{"token":"sk-..."}]',
+// 也可以按下标或已有的键覆盖单个字段, 例如 CHATGPT_BOT_TOKENS_0_TOKEN、CHATGPT_BOT_BACKENDS_AZURE_TOKEN
+const EnvPrefix = "CHATGPT_BOT_"
+
+// applyEnvOverrides 使用 prefix 开头的环境变量覆盖配置
+func applyEnvOverrides(conf *ChatGptConf, prefix string) error {
+	return applyEnvToStruct(reflect.ValueOf(conf).Elem(), prefix)
+}
+
+// BotEnvPrefix 多账号模式下账号配置的环境变量前缀, 例如账号 work 的 token 对应 CHATGPT_BOT_WORK_TOKEN
+// 账号名称转为大写, 字母与数字以外的字符替换为下划线
+func BotEnvPrefix(name string) string {
+	return EnvPrefix + botEnvName(name) + "_"
+}
+
+func botEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
+
+func applyEnvToStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		name := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+		envName := prefix + strings.ToUpper(name)
+		fieldValue := v.Field(idx)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if err := applyEnvToStruct(fieldValue, envName+"_"); err != nil {
+				return err
+			}
+			continue
+		case field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct:
+			if !hasEnvWithPrefix(envName + "_") {
+				continue
+			}
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(field.Type.Elem()))
+			}
+			if err := applyEnvToStruct(fieldValue.Elem(), envName+"_"); err != nil {
+				return err
+			}
+			continue
+		case isStructCollection(field.Type):
+			if err := applyEnvToCollection(fieldValue, envName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		if err := setFieldFromEnv(fieldValue, raw); err != nil {
+			return fmt.Errorf("环境变量 %s 解析失败: %s", envName, err.Error())
+		}
+	}
+	return nil
+}
+
+// isStructCollection 是否为元素是结构(或结构指针)的列表或映射
+func isStructCollection(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Pointer {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct
+}
+
+// applyEnvToCollection 使用JSON格式的环境变量整体替换列表或映射, 再按下标或键覆盖单个元素的字段
+func applyEnvToCollection(v reflect.Value, envName string) error {
+	if raw, ok := os.LookupEnv(envName); ok {
+		target := reflect.New(v.Type())
+		if err := json.Unmarshal([]byte(raw), target.Interface()); err != nil {
+			return fmt.Errorf("环境变量 %s 解析失败, 应为JSON格式: %s", envName, err.Error())
+		}
+		v.Set(target.Elem())
+	}
+	prefix := envName + "_"
+	names := envNamesWithPrefix(prefix)
+	if len(names) == 0 {
+		return nil
+	}
+	if v.Kind() == reflect.Slice {
+		return applyEnvToSlice(v, prefix, names)
+	}
+	return applyEnvToMap(v, prefix, names)
+}
+
+func applyEnvToSlice(v reflect.Value, prefix string, names []string) error {
+	indexes := make(map[int]bool)
+	for _, name := range names {
+		segment, _, ok := strings.Cut(strings.TrimPrefix(name, prefix), "_")
+		index, err := strconv.Atoi(segment)
+		if !ok || err != nil || index < 0 {
+			return fmt.Errorf("环境变量 %s 无法识别, 列表元素应使用 %s<下标>_<字段> 格式", name, prefix)
+		}
+		indexes[index] = true
+	}
+	for index := range indexes {
+		for v.Len() <= index {
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		}
+	}
+	for index := range indexes {
+		if err := applyEnvToElem(v.Index(index), fmt.Sprintf("%s%d_", prefix, index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyEnvToMap(v reflect.Value, prefix string, names []string) error {
+	keys := v.MapKeys()
+	// 优先匹配较长的键, 避免键名互为前缀时匹配错误
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i].String()) > len(keys[j].String())
+	})
+	matched := make(map[string]reflect.Value)
+	for _, name := range names {
+		found := false
+		for _, key := range keys {
+			if strings.HasPrefix(name, prefix+strings.ToUpper(key.String())+"_") {
+				matched[key.String()] = key
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("环境变量 %s 无法识别, 只能覆盖配置中已有的键, 新增的键请以JSON格式设置 %s", name, strings.TrimSuffix(prefix, "_"))
+		}
+	}
+	for name, key := range matched {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		elem.Set(v.MapIndex(key))
+		if err := applyEnvToElem(elem, prefix+strings.ToUpper(name)+"_"); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+// applyEnvToElem 覆盖列表或映射中单个元素的字段, elem 需可修改
+func applyEnvToElem(elem reflect.Value, prefix string) error {
+	if elem.Kind() == reflect.Pointer {
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		elem = elem.Elem()
+	}
+	return applyEnvToStruct(elem, prefix)
+}
+
+func envNamesWithPrefix(prefix string) []string {
+	names := make([]string, 0)
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func setFieldFromEnv(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64, reflect.Float32:
+		n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的类型: %s", v.Type())
+		}
+		items := make([]string, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Pointer:
+		// 指向数值等基本类型的可选字段, 未配置时为nil
+		elem := reflect.New(v.Type().Elem())
+		if err := setFieldFromEnv(elem.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的类型: %s", v.Type())
+		}
+		items := make(map[string]string)
+		for _, item := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("映射格式应为 key=value: %s", item)
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的类型: %s", v.Type())
+	}
+	return nil
+}
+
+// jsonFieldName 获取字段的json名称, 忽略未导出和标记为"-"的字段
+func jsonFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func hasEnvWithPrefix(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskSecret 遮盖敏感信息, 仅保留首尾少量字符
+func MaskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= 8 {
+		return "****"
+	}
+	return value[:3] + "****" + value[len(value)-4:]
+}
+
+// maskSecrets 遮盖配置中标记为 secret:"true" 的字段
+func maskSecrets(v reflect.Value) {
+	_ = walkSecrets(v, func(value string) (string, error) {
+		return MaskSecret(value), nil
+	})
+}
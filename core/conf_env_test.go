@@ -0,0 +1,135 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvToStruct(t *testing.T) {
+	t.Setenv(EnvPrefix+"TOKEN", "sk-env")
+	t.Setenv(EnvPrefix+"CONVERSATION_MAX_TOKENS", "2048")
+	t.Setenv(EnvPrefix+"SHOW_MODEL", "true")
+	t.Setenv(EnvPrefix+"GROUP_NAME_WHITE_LIST", "a, b,,c")
+	t.Setenv(EnvPrefix+"PROVIDER_BASE_URL", "http://127.0.0.1:8080/v1")
+	t.Setenv(EnvPrefix+"PROVIDER_AZURE_DEPLOYMENTS", "gpt-4=gpt4, gpt-3.5-turbo=gpt35")
+	t.Setenv(EnvPrefix+"WEBHOOK_MAX_RETRIES", "0")
+
+	conf := &ChatGptConf{Token: "sk-file", ConversationMaxTokens: 100}
+	if err := applyEnvOverrides(conf, EnvPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != "sk-env" || conf.ConversationMaxTokens != 2048 || !conf.ShowModel {
+		t.Fatalf("标量字段未覆盖: %+v", conf)
+	}
+	if !reflect.DeepEqual(conf.GroupNameWhiteList, []string{"a", "b", "c"}) {
+		t.Fatalf("group_name_white_list = %v", conf.GroupNameWhiteList)
+	}
+	if conf.Provider == nil || conf.Provider.BaseURL != "http://127.0.0.1:8080/v1" {
+		t.Fatalf("嵌套字段未覆盖: %+v", conf.Provider)
+	}
+	want := map[string]string{"gpt-4": "gpt4", "gpt-3.5-turbo": "gpt35"}
+	if !reflect.DeepEqual(conf.Provider.AzureDeployments, want) {
+		t.Fatalf("azure_deployments = %v", conf.Provider.AzureDeployments)
+	}
+	if conf.Webhook == nil || conf.Webhook.MaxRetries == nil || *conf.Webhook.MaxRetries != 0 {
+		t.Fatalf("可选数值字段未覆盖: %+v", conf.Webhook)
+	}
+}
+
+func TestApplyEnvToStructSkipsUnsetPointer(t *testing.T) {
+	conf := &ChatGptConf{}
+	if err := applyEnvOverrides(conf, EnvPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Provider != nil || conf.Http != nil {
+		t.Fatal("未设置环境变量时不应创建嵌套配置")
+	}
+}
+
+func TestApplyEnvToStructInvalidValue(t *testing.T) {
+	t.Setenv(EnvPrefix+"CONVERSATION_TIMEOUT", "abc")
+	err := applyEnvOverrides(&ChatGptConf{}, EnvPrefix)
+	if err == nil || !strings.Contains(err.Error(), EnvPrefix+"CONVERSATION_TIMEOUT") {
+		t.Fatalf("err = %v, want 解析失败", err)
+	}
+}
+
+func TestApplyEnvToStructCollections(t *testing.T) {
+	t.Setenv(EnvPrefix+"TOKENS", `[{"token":"sk-json-0"},{"token":"sk-json-1"}]`)
+	t.Setenv(EnvPrefix+"TOKENS_1_TOKEN", "sk-index-1")
+	t.Setenv(EnvPrefix+"TOKENS_2_BASE_URL", "http://127.0.0.1/v1")
+	t.Setenv(EnvPrefix+"BACKENDS_AZURE_TOKEN", "sk-azure")
+
+	conf := &ChatGptConf{
+		Tokens:   []*KeyConf{{Token: "sk-file"}},
+		Backends: map[string]*BackendConf{"azure": {Token: "sk-file"}, "az": {Token: "sk-az"}},
+	}
+	if err := applyEnvOverrides(conf, EnvPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Tokens) != 3 || conf.Tokens[0].Token != "sk-json-0" || conf.Tokens[1].Token != "sk-index-1" ||
+		conf.Tokens[2].BaseURL != "http://127.0.0.1/v1" {
+		t.Fatalf("tokens = %+v %+v %+v", conf.Tokens[0], conf.Tokens[1], conf.Tokens[len(conf.Tokens)-1])
+	}
+	if conf.Backends["azure"].Token != "sk-azure" || conf.Backends["az"].Token != "sk-az" {
+		t.Fatalf("backends = %+v %+v", conf.Backends["azure"], conf.Backends["az"])
+	}
+}
+
+func TestApplyEnvToStructUnsupportedCollectionEnv(t *testing.T) {
+	cases := map[string]string{
+		EnvPrefix + "TOKENS_X_TOKEN":         "sk",
+		EnvPrefix + "BACKENDS_MISSING_TOKEN": "sk",
+		EnvPrefix + "FALLBACKS":              "not json",
+	}
+	for name, value := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			err := applyEnvOverrides(&ChatGptConf{}, EnvPrefix)
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Fatalf("err = %v, want %s 无法识别", err, name)
+			}
+		})
+	}
+}
+
+func TestBotEnvPrefix(t *testing.T) {
+	if got := BotEnvPrefix("work-2"); got != EnvPrefix+"WORK_2_" {
+		t.Fatalf("BotEnvPrefix = %s", got)
+	}
+	t.Setenv(EnvPrefix+"TOKEN", "sk-shared")
+	t.Setenv(BotEnvPrefix("work")+"TOKEN", "sk-work")
+	work, family := &ChatGptConf{}, &ChatGptConf{}
+	if err := applyEnvOverrides(work, BotEnvPrefix("work")); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnvOverrides(family, BotEnvPrefix("family")); err != nil {
+		t.Fatal(err)
+	}
+	if work.Token != "sk-work" || family.Token != "" {
+		t.Fatalf("work = %s, family = %s, 账号只使用自己的环境变量", work.Token, family.Token)
+	}
+}
+
+func TestMultiBotConfValidateEnvPrefixes(t *testing.T) {
+	cases := []struct {
+		names []string
+		valid bool
+	}{
+		{names: []string{"work", "family"}, valid: true},
+		{names: []string{"work", "Work!"}},
+		{names: []string{"a", "a_tokens"}},
+		{names: []string{"http"}},
+		{names: []string{"secret"}},
+	}
+	for _, c := range cases {
+		conf := &MultiBotConf{}
+		for _, name := range c.names {
+			conf.Bots = append(conf.Bots, &BotConf{Name: name, ConfigFile: name + ".json"})
+		}
+		if err := conf.Validate(); (err == nil) != c.valid {
+			t.Errorf("%v: err = %v, valid = %v", c.names, err, c.valid)
+		}
+	}
+}
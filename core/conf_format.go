@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConfFormatJson = "json"
+	ConfFormatYaml = "yaml"
+	ConfFormatToml = "toml"
+)
+
+// DetectConfFormat 根据文件扩展名判断配置格式, 未知扩展名按json处理
+func DetectConfFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return ConfFormatYaml
+	case ".toml":
+		return ConfFormatToml
+	default:
+		return ConfFormatJson
+	}
+}
+
+// unmarshalConf 按格式解析配置
+// yaml/toml 先解析为通用结构再转换为json, 使所有格式共用配置结构的json标签
+func unmarshalConf(format string, data []byte, conf interface{}) error {
+	if format == ConfFormatJson {
+		return json.Unmarshal(data, conf)
+	}
+
+	var values map[string]interface{}
+	switch format {
+	case ConfFormatYaml:
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return err
+		}
+	case ConfFormatToml:
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("不支持的配置格式: %s", format)
+	}
+	jsonData, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, conf)
+}
+
+// marshalConf 按格式序列化配置
+func marshalConf(format string, conf *ChatGptConf) ([]byte, error) {
+	jsonData, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil || format == ConfFormatJson {
+		return jsonData, err
+	}
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	normalizeValues(values)
+
+	switch format {
+	case ConfFormatYaml:
+		return yaml.Marshal(values)
+	case ConfFormatToml:
+		buf := bytes.Buffer{}
+		if err := toml.NewEncoder(&buf).Encode(values); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("不支持的配置格式: %s", format)
+	}
+}
+
+// normalizeValues 移除空值并还原数字类型, toml 无法表示 null 且需要区分整数与浮点数
+func normalizeValues(values map[string]interface{}) {
+	for key, value := range values {
+		switch v := value.(type) {
+		case nil:
+			delete(values, key)
+		case json.Number:
+			values[key] = normalizeNumber(v)
+		case map[string]interface{}:
+			normalizeValues(v)
+		case []interface{}:
+			for idx, item := range v {
+				switch itemValue := item.(type) {
+				case json.Number:
+					v[idx] = normalizeNumber(itemValue)
+				case map[string]interface{}:
+					normalizeValues(itemValue)
+				}
+			}
+		}
+	}
+}
+
+func normalizeNumber(number json.Number) interface{} {
+	if n, err := number.Int64(); err == nil {
+		return n
+	}
+	f, _ := number.Float64()
+	return f
+}
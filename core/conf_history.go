@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultConfHistoryLimit = 20
+
+// ConfHistoryEntry 配置历史版本, 记录每次保存后的配置内容及变更信息
+type ConfHistoryEntry struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Operator string    `json:"operator"`
+	Action   string    `json:"action"`
+	Content  string    `json:"content"`
+}
+
+// String 历史版本摘要
+func (e *ConfHistoryEntry) String() string {
+	return fmt.Sprintf("v%d %s %s: %s", e.Version, e.Time.Format(TimeFormat), e.Operator, e.Action)
+}
+
+// ConfHistory 配置历史目录, 保留最近 limit 个版本
+type ConfHistory struct {
+	dir   string
+	limit int
+}
+
+// NewConfHistory 创建配置历史, dir 为空时使用配置文件同级的 <配置文件名>.history 目录
+func NewConfHistory(file string, dir string, limit int) *ConfHistory {
+	if dir == "" {
+		dir = file + ".history"
+	}
+	if limit <= 0 {
+		limit = defaultConfHistoryLimit
+	}
+	return &ConfHistory{dir: dir, limit: limit}
+}
+
+// List 按版本从新到旧列出历史
+func (h *ConfHistory) List() ([]*ConfHistoryEntry, error) {
+	files, err := os.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "读取配置历史目录失败")
+	}
+	entries := make([]*ConfHistoryEntry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		entry, err := h.read(filepath.Join(h.dir, file.Name()))
+		if err != nil {
+			ComponentLogger(LogComponentConfig).Warn("读取配置历史失败: " + err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Version > entries[j].Version
+	})
+	return entries, nil
+}
+
+// Get 获取指定版本
+func (h *ConfHistory) Get(version int) (*ConfHistoryEntry, error) {
+	entry, err := h.read(h.path(version))
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, fmt.Errorf("配置历史版本不存在: v%d", version)
+	}
+	return entry, err
+}
+
+// Append 追加一个历史版本, 并清理超出数量限制的旧版本
+func (h *ConfHistory) Append(operator, action string, content []byte) (*ConfHistoryEntry, error) {
+	entries, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+	entry := &ConfHistoryEntry{
+		Version:  1,
+		Time:     time.Now(),
+		Operator: operator,
+		Action:   action,
+		Content:  string(content),
+	}
+	if len(entries) > 0 {
+		entry.Version = entries[0].Version + 1
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "创建配置历史目录失败")
+	}
+	if err := writeFileAtomic(h.path(entry.Version), data, 0600); err != nil {
+		return nil, err
+	}
+
+	entries = append([]*ConfHistoryEntry{entry}, entries...)
+	if len(entries) <= h.limit {
+		return entry, nil
+	}
+	for _, expired := range entries[h.limit:] {
+		if err := os.Remove(h.path(expired.Version)); err != nil {
+			ComponentLogger(LogComponentConfig).Warn("清理配置历史失败: " + err.Error())
+		}
+	}
+	return entry, nil
+}
+
+// IsEmpty 是否还没有任何历史版本
+func (h *ConfHistory) IsEmpty() bool {
+	entries, err := h.List()
+	return err == nil && len(entries) == 0
+}
+
+func (h *ConfHistory) path(version int) string {
+	return filepath.Join(h.dir, fmt.Sprintf("v%06d.json", version))
+}
+
+func (h *ConfHistory) read(path string) (*ConfHistoryEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	entry := &ConfHistoryEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, errors.Wrap(err, "解析配置历史失败: "+path)
+	}
+	return entry, nil
+}
+
+// writeFileAtomic 先写入同目录的临时文件再重命名, 避免写入中途崩溃导致文件损坏
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(file); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "创建临时文件失败")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "写入临时文件失败")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "同步临时文件失败")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "关闭临时文件失败")
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return errors.Wrap(err, "设置文件权限失败")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), file), "替换文件失败")
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// EncryptedPrefix 加密字段的前缀, 格式为 enc:base64(nonce+密文)
+	EncryptedPrefix = "enc:"
+	// DefaultSecretKeyEnv 默认的加密密钥环境变量
+	DefaultSecretKeyEnv = "CHATGPT_BOT_SECRET_KEY"
+)
+
+// resolveSecrets 解析配置中的敏感字段, 只作用于内存中的生效配置
+// token 为空时依次尝试 token_env、token_file(tokens 与 backends 中的密钥同理), 随后解密所有 enc: 开头的敏感字段
+func resolveSecrets(conf *ChatGptConf) error {
+	var err error
+	if conf.Token, err = resolveToken(conf.Token, conf.TokenEnv, conf.TokenFile); err != nil {
+		return err
+	}
+	if err = resolveKeyTokens(conf.Tokens); err != nil {
+		return err
+	}
+	for name, backend := range conf.Backends {
+		if backend.Token, err = resolveToken(backend.Token, backend.TokenEnv, backend.TokenFile); err != nil {
+			return errors.WithMessage(err, "backend "+name)
+		}
+		if err = resolveKeyTokens(backend.Tokens); err != nil {
+			return errors.WithMessage(err, "backend "+name)
+		}
+	}
+
+	var key []byte
+	return walkSecrets(reflect.ValueOf(conf), func(value string) (string, error) {
+		if !strings.HasPrefix(value, EncryptedPrefix) {
+			return value, nil
+		}
+		if key == nil {
+			if key, err = loadSecretKey(conf.SecretKeyEnv, conf.SecretKeyFile); err != nil {
+				return "", err
+			}
+		}
+		return DecryptSecret(key, value)
+	})
+}
+
+// resolveKeyTokens 解析密钥列表中的间接引用
+func resolveKeyTokens(keys []*KeyConf) error {
+	for _, key := range keys {
+		// 空的密钥项由 Validate 报错
+		if key == nil {
+			continue
+		}
+		token, err := resolveToken(key.Token, key.TokenEnv, key.TokenFile)
+		if err != nil {
+			return err
+		}
+		key.Token = token
+	}
+	return nil
+}
+
+// resolveToken 解析密钥的间接引用
+func resolveToken(token, tokenEnv, tokenFile string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if tokenEnv != "" {
+		if value := strings.TrimSpace(os.Getenv(tokenEnv)); value != "" {
+			return value, nil
+		}
+	}
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", errors.Wrap(err, "读取token_file失败")
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
+
+// minSecretKeyLength 建议的密钥材料最小长度
+const minSecretKeyLength = 32
+
+// loadSecretKey 读取加密密钥, 优先使用环境变量, 其次使用密钥文件
+func loadSecretKey(keyEnv, keyFile string) ([]byte, error) {
+	if keyEnv == "" {
+		keyEnv = DefaultSecretKeyEnv
+	}
+	material := strings.TrimSpace(os.Getenv(keyEnv))
+	if material == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "读取secret_key_file失败")
+		}
+		material = strings.TrimSpace(string(data))
+	}
+	if material == "" {
+		return nil, fmt.Errorf("配置中存在加密字段, 但未提供密钥, 请设置环境变量%s或secret_key_file", keyEnv)
+	}
+	if len(material) < minSecretKeyLength {
+		ComponentLogger(LogComponentConfig).Warn(fmt.Sprintf("密钥长度不足%d个字符, 容易被暴力破解, 建议使用 openssl rand -base64 32 生成", minSecretKeyLength))
+	}
+	// 对任意长度的密钥材料做摘要, 得到AES-256所需的32字节密钥
+	// 摘要不是口令派生函数, 无法减缓暴力破解, 密钥材料本身必须是高熵的随机值而不是易记的口令
+	sum := sha256.Sum256([]byte(material))
+	return sum[:], nil
+}
+
+// EncryptSecret 使用AES-GCM加密敏感字段
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 生成的字段
+func DecryptSecret(key []byte, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "加密字段格式错误")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("加密字段格式错误")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("加密字段解密失败, 请检查密钥")
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// walkSecrets 遍历配置中标记为 secret:"true" 的字符串字段(或字符串映射中的值)并替换
+func walkSecrets(v reflect.Value, replace func(value string) (string, error)) error {
+	return walkSecretPaths(v, "", func(path, value string) (string, error) {
+		return replace(value)
+	})
+}
+
+// walkSecretPaths 与 walkSecrets 相同, 同时提供字段路径, 例如 tokens[0].token、backends.azure.token
+func walkSecretPaths(v reflect.Value, path string, replace func(path, value string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return walkSecretPaths(v.Elem(), path, replace)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := jsonFieldName(field)
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			fieldValue := v.Field(idx)
+			if field.Tag.Get("secret") == "true" && fieldValue.Kind() == reflect.String {
+				value, err := replace(fieldPath, fieldValue.String())
+				if err != nil {
+					return errors.WithMessage(err, fieldPath)
+				}
+				fieldValue.SetString(value)
+				continue
+			}
+			// 标记为敏感的 map[string]string (如请求头) 中的每个值都是敏感字段
+			if field.Tag.Get("secret") == "true" && fieldValue.Kind() == reflect.Map &&
+				fieldValue.Type().Elem().Kind() == reflect.String {
+				for _, key := range fieldValue.MapKeys() {
+					itemPath := fmt.Sprintf("%s.%v", fieldPath, key.Interface())
+					value, err := replace(itemPath, fieldValue.MapIndex(key).String())
+					if err != nil {
+						return errors.WithMessage(err, itemPath)
+					}
+					fieldValue.SetMapIndex(key, reflect.ValueOf(value).Convert(fieldValue.Type().Elem()))
+				}
+				continue
+			}
+			if err := walkSecretPaths(fieldValue, fieldPath, replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for idx := 0; idx < v.Len(); idx++ {
+			if err := walkSecretPaths(v.Index(idx), fmt.Sprintf("%s[%d]", path, idx), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if item := v.MapIndex(key); item.Kind() == reflect.Pointer {
+				if err := walkSecretPaths(item, fmt.Sprintf("%s.%v", path, key.Interface()), replace); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isMaskedSecret 是否为 MaskSecret 生成的遮盖值
+func isMaskedSecret(value string) bool {
+	return value == "****" || (len(value) == 11 && value[3:7] == "****")
+}
+
+// restoreSecrets 将仍为遮盖值的敏感字段还原为原配置中同一路径的值, 用于保存从接口提交的遮盖后配置
+// 遮盖值与原配置中同一路径的值不对应时(例如调整了密钥顺序)返回错误, 避免把遮盖值写入配置
+func restoreSecrets(conf *ChatGptConf, previous *ChatGptConf) error {
+	originals := make(map[string]string)
+	if previous != nil {
+		_ = walkSecretPaths(reflect.ValueOf(previous), "", func(path, value string) (string, error) {
+			originals[path] = value
+			return value, nil
+		})
+	}
+	return walkSecretPaths(reflect.ValueOf(conf), "", func(path, value string) (string, error) {
+		if !isMaskedSecret(value) {
+			return value, nil
+		}
+		if original := originals[path]; original != "" && MaskSecret(original) == value {
+			return original, nil
+		}
+		return "", errors.New("仍为遮盖值, 但与原配置中同一字段的值不对应, 请填写原文")
+	})
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles 内置的管理页面, 页面本身不含数据, 通过 /api/ 管理接口读取和修改
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler 管理页面的静态文件服务
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,198 @@
+// 管理页面: 通过 /api/ 管理接口读取数据, admin_token 保存在浏览器 localStorage 中
+(function () {
+  const refreshInterval = 3000;
+  let selectedConversation = "";
+  let selectedBot = localStorage.getItem("chatgpt-bot-admin-bot") || "";
+
+  const $ = (id) => document.getElementById(id);
+
+  function token() {
+    return localStorage.getItem("chatgpt-bot-admin-token") || "";
+  }
+
+  async function api(method, path, body) {
+    const options = { method: method, headers: { Authorization: "Bearer " + token() } };
+    if (body !== undefined) {
+      options.body = body;
+    }
+    const separator = path.indexOf("?") === -1 ? "?" : "&";
+    const resp = await fetch("api/" + path + separator + "bot=" + encodeURIComponent(selectedBot), options);
+    const text = await resp.text();
+    if (!resp.ok) {
+      let message = text;
+      try {
+        message = JSON.parse(text).error;
+      } catch (e) {
+        // 非JSON错误直接展示原文
+      }
+      throw new Error(resp.status + " " + message);
+    }
+    return text;
+  }
+
+  async function apiJson(method, path) {
+    return JSON.parse(await api(method, path));
+  }
+
+  function showError(err) {
+    $("error").hidden = !err;
+    $("error").textContent = err ? err.message : "";
+  }
+
+  function fillTable(id, rows) {
+    const tbody = $(id).querySelector("tbody");
+    tbody.replaceChildren();
+    rows.forEach((cells) => {
+      const tr = document.createElement("tr");
+      cells.forEach((cell) => {
+        const td = document.createElement("td");
+        if (cell instanceof Node) {
+          td.appendChild(cell);
+        } else {
+          td.textContent = cell === undefined ? "" : cell;
+        }
+        tr.appendChild(td);
+      });
+      tbody.appendChild(tr);
+    });
+    return tbody;
+  }
+
+  async function refreshBots() {
+    const bots = await apiJson("GET", "bots");
+    if (!bots.some((bot) => bot.name === selectedBot)) {
+      selectedBot = bots.length ? bots[0].name : "";
+    }
+    const select = $("bot");
+    select.hidden = bots.length < 2;
+    select.replaceChildren(...bots.map((bot) => new Option(bot.name, bot.name, false, bot.name === selectedBot)));
+  }
+
+  async function refreshLogin() {
+    const status = await apiJson("GET", "login");
+    const badge = $("login-state");
+    badge.textContent = { online: "在线", pending: "等待扫码", offline: "已掉线", reconnecting: "重新登录中" }[status.state] || status.state;
+    badge.className = "badge " + status.state;
+    $("login-section").hidden = !status.qrcode_url;
+    if (status.qrcode_url && $("qrcode").getAttribute("src") !== status.qrcode_url) {
+      $("qrcode").src = status.qrcode_url;
+      $("qrcode-link").href = status.qrcode_url;
+      $("qrcode-link").textContent = status.qrcode_url;
+    }
+  }
+
+  async function refreshConversations() {
+    const conversations = await apiJson("GET", "conversations");
+    const tbody = fillTable("conversations", conversations.map((item) => {
+      const clear = document.createElement("button");
+      clear.textContent = "清除";
+      clear.onclick = async (event) => {
+        event.stopPropagation();
+        await api("DELETE", "conversations/" + encodeURIComponent(item.key));
+        refresh();
+      };
+      return [item.key, item.messages, item.last_active_at, clear];
+    }));
+    Array.from(tbody.children).forEach((tr, idx) => {
+      const key = conversations[idx].key;
+      tr.classList.toggle("selected", key === selectedConversation);
+      tr.onclick = () => {
+        selectedConversation = key;
+        refresh();
+      };
+    });
+    if (selectedConversation) {
+      await refreshConversation();
+    }
+  }
+
+  async function refreshConversation() {
+    const container = $("conversation");
+    let messages = [];
+    try {
+      messages = await apiJson("GET", "conversations/" + encodeURIComponent(selectedConversation));
+    } catch (e) {
+      selectedConversation = "";
+    }
+    container.replaceChildren();
+    messages.forEach((message) => {
+      const div = document.createElement("div");
+      div.className = "message " + message.role;
+      const meta = document.createElement("div");
+      meta.className = "meta";
+      meta.textContent = message.role + " " + new Date(message.Timestamp * 1000).toLocaleString();
+      div.appendChild(meta);
+      div.appendChild(document.createTextNode(message.content));
+      container.appendChild(div);
+    });
+    container.scrollTop = container.scrollHeight;
+  }
+
+  async function refreshActivity() {
+    const activity = await apiJson("GET", "activity");
+    fillTable("activity", activity.map((item) => [item.group, item.messages, item.replies, item.last_message_at]));
+  }
+
+  async function refreshUsage() {
+    const usage = await apiJson("GET", "usage");
+    const total = usage.total;
+    $("usage-total").textContent = "合计: " + total.requests + " 次请求, 提示 " + total.prompt_tokens
+      + " tokens, 回复 " + total.completion_tokens + " tokens";
+    fillTable("usage", Object.keys(usage.by_model).sort().map((model) => {
+      const stat = usage.by_model[model];
+      return [model, stat.requests, stat.prompt_tokens, stat.completion_tokens];
+    }));
+  }
+
+  async function loadConfig() {
+    $("config").value = await api("GET", "config");
+    $("config-result").textContent = "";
+  }
+
+  async function saveConfig() {
+    try {
+      await api("PUT", "config", $("config").value);
+      $("config-result").textContent = "已保存";
+      await loadConfig();
+    } catch (e) {
+      $("config-result").textContent = e.message;
+    }
+  }
+
+  async function refresh() {
+    if (!token()) {
+      showError(new Error("请输入 admin_token"));
+      return;
+    }
+    try {
+      await refreshBots();
+      await Promise.all([refreshLogin(), refreshConversations(), refreshActivity(), refreshUsage()]);
+      showError(null);
+    } catch (e) {
+      showError(e);
+    }
+  }
+
+  $("token-form").onsubmit = (event) => {
+    event.preventDefault();
+    localStorage.setItem("chatgpt-bot-admin-token", $("token").value);
+    refresh();
+    loadConfig().catch(showError);
+  };
+  $("bot").onchange = () => {
+    selectedBot = $("bot").value;
+    selectedConversation = "";
+    localStorage.setItem("chatgpt-bot-admin-bot", selectedBot);
+    refresh();
+    loadConfig().catch(showError);
+  };
+  $("config-load").onclick = () => loadConfig().catch(showError);
+  $("config-save").onclick = saveConfig;
+
+  $("token").value = token();
+  refresh();
+  if (token()) {
+    loadConfig().catch(showError);
+  }
+  setInterval(refresh, refreshInterval);
+})();
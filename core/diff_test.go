@@ -0,0 +1,29 @@
+package core
+
+import "testing"
+
+func TestDiffChangedLines(t *testing.T) {
+	cases := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{name: "相同", before: "a\nb\n", after: "a\nb", want: ""},
+		{name: "修改", before: "a\nb\nc", after: "a\nx\nc", want: "- b\n+ x\n"},
+		{name: "新增", before: "a\nc", after: "a\nb\nc", want: "+ b\n"},
+		{name: "删除", before: "a\nb\nc", after: "a\nc", want: "- b\n"},
+		{name: "末尾", before: "a", after: "a\nb\nc", want: "+ b\n+ c\n"},
+	}
+	for _, c := range cases {
+		if got := DiffChangedLines(c.before, c.after); got != c.want {
+			t.Errorf("%s: DiffChangedLines = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDiffLinesKeepsUnchangedLines(t *testing.T) {
+	want := "  a\n- b\n+ x\n  c\n"
+	if got := DiffLines("a\nb\nc", "a\nx\nc"); got != want {
+		t.Fatalf("DiffLines = %q, want %q", got, want)
+	}
+}
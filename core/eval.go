@@ -0,0 +1,438 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var EvalCommand = &cobra.Command{
+	Use:   "eval <suite.yaml>",
+	Short: "运行提示词评测用例, 输出通过与失败的断言",
+	Long: fmt.Sprintf("评测用例中的每轮对话经过 buildCompletionRequest 与模型服务, 回复按断言评分\n" +
+		"断言: contains、regex、max_length、json_schema、rubric(由评分模型按评分标准判断)\n" +
+		"--mock 启动本地模拟模型服务, 使用用例中的 mock 回复, 无需网络与API密钥"),
+	Args: cobra.ExactArgs(1),
+	RunE: runEval,
+}
+
+var (
+	evalMock   bool
+	evalOutput string
+)
+
+func init() {
+	EvalCommand.Flags().StringVarP(&configFile, "configFile", "c", "chatgpt.json", "-c chatgpt.json")
+	EvalCommand.Flags().BoolVar(&evalMock, "mock", false, "--mock 使用本地模拟模型服务, 离线运行")
+	EvalCommand.Flags().StringVarP(&evalOutput, "output", "o", "", "-o report.txt 报告写入文件, 默认输出到标准输出")
+}
+
+// EvalSuite 评测用例集
+type EvalSuite struct {
+	// CharacterDesc 覆盖配置中的提示词, 为空时使用配置
+	CharacterDesc string `yaml:"character_desc,omitempty"`
+	// Model 覆盖配置中的模型, 为空时使用配置
+	Model string `yaml:"model,omitempty"`
+	// JudgeModel rubric 断言使用的评分模型, 为空时使用对话的模型
+	JudgeModel string      `yaml:"judge_model,omitempty"`
+	Cases      []*EvalCase `yaml:"cases"`
+}
+
+// EvalCase 一段对话, 每个用例使用独立的对话上下文
+type EvalCase struct {
+	Name string `yaml:"name"`
+	// Group 模拟群聊名称, 用于选择群聊的模型配置
+	Group string      `yaml:"group,omitempty"`
+	Turns []*EvalTurn `yaml:"turns"`
+}
+
+// EvalTurn 一轮对话
+type EvalTurn struct {
+	User string `yaml:"user"`
+	// Mock 使用 --mock 时模拟模型服务返回的回复
+	Mock string `yaml:"mock,omitempty"`
+	// JudgeMock 使用 --mock 时本轮 rubric 断言的评分结果, 为空时判定通过
+	JudgeMock *bool            `yaml:"judge_mock,omitempty"`
+	Assert    []*EvalAssertion `yaml:"assert,omitempty"`
+}
+
+// EvalAssertion 对回复的断言, 每个断言只填写一种
+type EvalAssertion struct {
+	Contains   string                 `yaml:"contains,omitempty"`
+	Regex      string                 `yaml:"regex,omitempty"`
+	MaxLength  int                    `yaml:"max_length,omitempty"`
+	JSONSchema map[string]interface{} `yaml:"json_schema,omitempty"`
+	// Rubric 评分标准, 由评分模型判断回复是否满足
+	Rubric string `yaml:"rubric,omitempty"`
+}
+
+// LoadEvalSuite 读取并校验评测用例集
+func LoadEvalSuite(file string) (*EvalSuite, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	suite := &EvalSuite{}
+	if err := yaml.Unmarshal(data, suite); err != nil {
+		return nil, errors.Wrapf(err, "解析评测用例 %s 失败", file)
+	}
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("评测用例 %s 中没有 cases", file)
+	}
+	for idx, evalCase := range suite.Cases {
+		if evalCase.Name == "" {
+			evalCase.Name = fmt.Sprintf("case-%d", idx+1)
+		}
+		if len(evalCase.Turns) == 0 {
+			return nil, fmt.Errorf("cases[%s]: turns 不能为空", evalCase.Name)
+		}
+		for turnIdx, turn := range evalCase.Turns {
+			if turn.User == "" {
+				return nil, fmt.Errorf("cases[%s].turns[%d]: user 不能为空", evalCase.Name, turnIdx)
+			}
+			for _, assertion := range turn.Assert {
+				if err := assertion.validate(); err != nil {
+					return nil, errors.WithMessagef(err, "cases[%s].turns[%d]", evalCase.Name, turnIdx)
+				}
+			}
+		}
+	}
+	return suite, nil
+}
+
+func (a *EvalAssertion) validate() error {
+	count := 0
+	for _, set := range []bool{a.Contains != "", a.Regex != "", a.MaxLength > 0, a.JSONSchema != nil, a.Rubric != ""} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return errors.New("每个断言只能填写 contains、regex、max_length、json_schema、rubric 中的一种")
+	}
+	if a.Regex != "" {
+		if _, err := regexp.Compile(a.Regex); err != nil {
+			return errors.Wrap(err, "regex 格式错误")
+		}
+	}
+	if a.JSONSchema != nil {
+		// yaml 解析的数字为 int, 转换为与 json 解析结果相同的类型
+		data, err := json.Marshal(a.JSONSchema)
+		if err != nil {
+			return errors.Wrap(err, "json_schema 格式错误")
+		}
+		a.JSONSchema = nil
+		if err := json.Unmarshal(data, &a.JSONSchema); err != nil {
+			return errors.Wrap(err, "json_schema 格式错误")
+		}
+	}
+	return nil
+}
+
+// String 断言的简短描述, 用于报告
+func (a *EvalAssertion) String() string {
+	switch {
+	case a.Contains != "":
+		return "contains " + a.Contains
+	case a.Regex != "":
+		return "regex " + a.Regex
+	case a.MaxLength > 0:
+		return fmt.Sprintf("max_length %d", a.MaxLength)
+	case a.JSONSchema != nil:
+		return "json_schema"
+	default:
+		return "rubric " + a.Rubric
+	}
+}
+
+// evalReport 评测结果统计
+type evalReport struct {
+	Passed int
+	Failed int
+}
+
+func runEval(cmd *cobra.Command, args []string) error {
+	suite, err := LoadEvalSuite(args[0])
+	if err != nil {
+		return err
+	}
+	instance, err := NewBotInstance(DefaultBotName, configFile, "", EnvPrefix)
+	if err != nil {
+		return err
+	}
+
+	var mockUrl string
+	if evalMock {
+		server, err := startEvalMockServer(suite, instance.componentLogger(LogComponentLLM))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := server.Close(); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err.Error())
+			}
+		}()
+		mockUrl = server.Url()
+	}
+	conf, err := instance.confHelper.Update(func(conf *ChatGptConf) {
+		if suite.CharacterDesc != "" {
+			conf.SetDefaultPrompt(suite.CharacterDesc)
+		}
+		if suite.Model != "" {
+			conf.Model = suite.Model
+			for _, profile := range conf.ModelProfiles {
+				profile.Model = suite.Model
+			}
+		}
+		if mockUrl != "" {
+			conf.useEvalMockServer(mockUrl)
+		}
+	})
+	if err != nil {
+		return errors.WithMessage(err, "应用评测配置失败")
+	}
+	if evalMock {
+		backends, err := NewLLMBackends(conf, nil)
+		if err != nil {
+			return err
+		}
+		instance.handler.backends, instance.handler.completer = backends, backends
+	}
+
+	out := cmd.OutOrStdout()
+	if evalOutput != "" {
+		file, err := os.Create(evalOutput)
+		if err != nil {
+			return errors.Wrap(err, "创建报告文件失败")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	report := &evalReport{}
+	for _, evalCase := range suite.Cases {
+		instance.handler.evalCase(out, suite, evalCase, report)
+	}
+	fmt.Fprintf(out, "共 %d 个断言: 通过 %d, 失败 %d\n", report.Passed+report.Failed, report.Passed, report.Failed)
+	if report.Failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d 个断言失败", report.Failed)
+	}
+	return nil
+}
+
+// evalCase 在独立的对话上下文中逐轮请求模型并检查断言
+func (h MessageHandler) evalCase(out io.Writer, suite *EvalSuite, evalCase *EvalCase, report *evalReport) {
+	fmt.Fprintf(out, "=== %s\n", evalCase.Name)
+	senderName := "Eval:" + evalCase.Name
+	h.chatContext.Clear(senderName)
+	defer h.chatContext.Clear(senderName)
+
+	for idx, turn := range evalCase.Turns {
+		fmt.Fprintf(out, "[%d] > %s\n", idx+1, turn.User)
+		reply, model, _, err := h.completeText(openwechat.MsgTypeText.String(), senderName, evalCase.Group, turn.User,
+			&EventRecord{}, time.Now())
+		if err != nil {
+			// 模型请求失败时本轮所有断言失败, 后续轮次的上下文已不完整
+			fmt.Fprintf(out, "    请求失败: %s\n", err.Error())
+			for _, assertion := range turn.Assert {
+				report.Failed++
+				fmt.Fprintf(out, "    FAIL %s\n", assertion)
+			}
+			return
+		}
+		fmt.Fprintf(out, "    < [%s] %s\n", model, strings.ReplaceAll(strings.TrimSpace(reply), "\n", "\n      "))
+
+		for _, assertion := range turn.Assert {
+			judgeModel := suite.JudgeModel
+			if judgeModel == "" {
+				judgeModel = model
+			}
+			if reason := h.checkAssertion(assertion, turn.User, reply, judgeModel); reason != "" {
+				report.Failed++
+				fmt.Fprintf(out, "    FAIL %s: %s\n", assertion, reason)
+			} else {
+				report.Passed++
+				fmt.Fprintf(out, "    PASS %s\n", assertion)
+			}
+		}
+	}
+}
+
+// checkAssertion 检查回复是否满足断言, 返回失败原因, 通过时返回空字符串
+func (h MessageHandler) checkAssertion(assertion *EvalAssertion, question, reply, judgeModel string) string {
+	switch {
+	case assertion.Contains != "":
+		if !strings.Contains(reply, assertion.Contains) {
+			return "回复中没有该内容"
+		}
+	case assertion.Regex != "":
+		if !regexp.MustCompile(assertion.Regex).MatchString(reply) {
+			return "回复不匹配"
+		}
+	case assertion.MaxLength > 0:
+		if length := utf8.RuneCountInString(strings.TrimSpace(reply)); length > assertion.MaxLength {
+			return fmt.Sprintf("回复长度为 %d", length)
+		}
+	case assertion.JSONSchema != nil:
+		var value interface{}
+		if err := json.Unmarshal([]byte(trimCodeFence(reply)), &value); err != nil {
+			return "回复不是JSON: " + err.Error()
+		}
+		if err := validateJSONSchema("$", assertion.JSONSchema, value); err != nil {
+			return err.Error()
+		}
+	default:
+		pass, reason, err := h.judge(assertion.Rubric, question, reply, judgeModel)
+		if err != nil {
+			return "评分失败: " + err.Error()
+		}
+		if !pass {
+			return reason
+		}
+	}
+	return ""
+}
+
+// evalJudgePrompt 评分模型的系统提示, 模拟模型服务据此识别评分请求
+const evalJudgePrompt = "你是严格的评分员。根据评分标准判断回答是否合格, " +
+	`只输出JSON: {"pass": true或false, "reason": "简短的理由"}`
+
+// evalJudgeQuestion 与 evalJudgeAnswer 分隔评分请求中的问题与回答
+const (
+	evalJudgeQuestion = "\n\n问题:\n"
+	evalJudgeAnswer   = "\n\n回答:\n"
+)
+
+// evalJudgement 评分模型的输出
+type evalJudgement struct {
+	Pass   bool   `json:"pass"`
+	Reason string `json:"reason"`
+}
+
+// judge 请求评分模型按评分标准判断回复
+func (h MessageHandler) judge(rubric, question, reply, model string) (bool, string, error) {
+	req := openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: evalJudgePrompt},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "评分标准:\n" + rubric + evalJudgeQuestion + question + evalJudgeAnswer + reply,
+			},
+		},
+		Temperature: 0,
+	}
+	resp, _, err := h.completer.CreateChatCompletion(context.Background(), &ModelProfile{Model: model}, req)
+	if err != nil {
+		return false, "", err
+	}
+	if len(resp.Choices) == 0 {
+		return false, "", errors.New("评分模型没有返回结果")
+	}
+	judgement := &evalJudgement{}
+	if err := json.Unmarshal([]byte(trimCodeFence(resp.Choices[0].Message.Content)), judgement); err != nil {
+		return false, "", errors.Wrap(err, "评分模型输出不是JSON")
+	}
+	return judgement.Pass, judgement.Reason, nil
+}
+
+// trimCodeFence 去掉模型输出中包裹JSON的 ``` 代码块标记
+func trimCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	if idx := strings.Index(content, "\n"); idx >= 0 {
+		content = content[idx+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
+
+// validateJSONSchema 按 JSON Schema 的常用子集校验: type、properties、required、items、enum
+func validateJSONSchema(path string, schema map[string]interface{}, value interface{}) error {
+	if schemaType, ok := schema["type"].(string); ok && !matchJSONType(schemaType, value) {
+		return fmt.Errorf("%s: 期望类型 %s", path, schemaType)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		matched := false
+		for _, item := range enum {
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: 取值 %v 不在 enum 中", path, value)
+		}
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := typed[fmt.Sprint(name)]; !ok {
+					return fmt.Errorf("%s: 缺少字段 %v", path, name)
+				}
+			}
+		}
+		if properties, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, propertySchema := range properties {
+				property, ok := typed[name]
+				propertyMap, isMap := propertySchema.(map[string]interface{})
+				if !ok || !isMap {
+					continue
+				}
+				if err := validateJSONSchema(path+"."+name, propertyMap, property); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range typed {
+				if err := validateJSONSchema(fmt.Sprintf("%s[%d]", path, idx), items, item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func matchJSONType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == float64(int64(number))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evalMockToken 模拟模型服务不校验API密钥, 只用于通过配置校验
+const evalMockToken = "sk-eval-mock"
+
+// evalMockServer 兼容OpenAI接口的本地模拟模型服务, 按用户消息返回评测用例中的 mock 回复
+type evalMockServer struct {
+	listener net.Listener
+	server   *http.Server
+	// served 服务退出的原因, Close 后为 http.ErrServerClosed
+	served chan error
+	// replies 用户消息到 mock 回复的映射, 相同的消息按用例顺序依次返回
+	replies map[string][]string
+	// verdicts 用户消息到评分结果的映射, 每个 rubric 断言一项, 按用例顺序依次返回
+	verdicts map[string][]bool
+	mu       sync.Mutex
+}
+
+// startEvalMockServer 在本地随机端口启动模拟模型服务, 服务异常退出时记录日志
+func startEvalMockServer(suite *EvalSuite, logger *zap.Logger) (*evalMockServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &evalMockServer{listener: listener, served: make(chan error, 1), replies: make(map[string][]string),
+		verdicts: make(map[string][]bool)}
+	for _, evalCase := range suite.Cases {
+		for _, turn := range evalCase.Turns {
+			// 与 lastUserMessage 一致去掉首尾空白, YAML 块标量末尾带有换行
+			key := strings.TrimSpace(turn.User)
+			server.replies[key] = append(server.replies[key], turn.Mock)
+			pass := turn.JudgeMock == nil || *turn.JudgeMock
+			for _, assertion := range turn.Assert {
+				if assertion.Rubric != "" {
+					server.verdicts[key] = append(server.verdicts[key], pass)
+				}
+			}
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", server.chatCompletions)
+	server.server = &http.Server{Handler: mux}
+	go func() {
+		err := server.server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("模拟模型服务异常退出: " + err.Error())
+		}
+		server.served <- err
+	}()
+	return server, nil
+}
+
+// Url 模拟模型服务的接口地址, 用作 provider.base_url
+func (s *evalMockServer) Url() string {
+	return fmt.Sprintf("http://%s/v1", s.listener.Addr().String())
+}
+
+// Close 关闭模拟模型服务, 服务此前异常退出时返回退出原因
+func (s *evalMockServer) Close() error {
+	if err := s.server.Close(); err != nil {
+		return err
+	}
+	if err := <-s.served; !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "模拟模型服务异常退出")
+	}
+	return nil
+}
+
+func (s *evalMockServer) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	req := openai.ChatCompletionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := openai.ChatCompletionResponse{
+		ID:      fmt.Sprintf("chatcmpl-mock-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: s.reply(req.Messages),
+			},
+			FinishReason: "stop",
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reply 评分请求返回问题对应的 judge_mock 结果, 其他请求返回最后一条用户消息对应的 mock 回复,
+// 未填写 mock 时原样返回用户消息
+func (s *evalMockServer) reply(messages []openai.ChatCompletionMessage) string {
+	if len(messages) > 0 && messages[0].Content == evalJudgePrompt {
+		return s.verdict(judgeQuestion(lastUserMessage(messages)))
+	}
+	content := lastUserMessage(messages)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replies := s.replies[content]
+	if len(replies) == 0 {
+		return "mock: " + content
+	}
+	// 未填写 mock 的轮次同样要出队, 否则后续相同消息的回复会错位
+	s.replies[content] = replies[1:]
+	if replies[0] == "" {
+		return "mock: " + content
+	}
+	return replies[0]
+}
+
+// verdict 依次返回问题对应的评分结果, 没有时判定通过
+func (s *evalMockServer) verdict(question string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	verdicts := s.verdicts[question]
+	if len(verdicts) == 0 {
+		return `{"pass": true, "reason": "模拟评分"}`
+	}
+	s.verdicts[question] = verdicts[1:]
+	if !verdicts[0] {
+		return `{"pass": false, "reason": "模拟评分: judge_mock 判定不通过"}`
+	}
+	return `{"pass": true, "reason": "模拟评分"}`
+}
+
+// judgeQuestion 从评分请求中取出问题, 格式见 MessageHandler.judge
+func judgeQuestion(content string) string {
+	_, rest, ok := strings.Cut(content, evalJudgeQuestion)
+	if !ok {
+		return ""
+	}
+	question, _, _ := strings.Cut(rest, evalJudgeAnswer)
+	return strings.TrimSpace(question)
+}
+
+// lastUserMessage 请求中最后一条用户消息的内容
+func lastUserMessage(messages []openai.ChatCompletionMessage) string {
+	for idx := len(messages) - 1; idx >= 0; idx-- {
+		if messages[idx].Role == openai.ChatMessageRoleUser {
+			return strings.TrimSpace(messages[idx].Content)
+		}
+	}
+	return ""
+}
+
+// useEvalMockServer 所有模型请求改为发送到模拟模型服务, 仅可在 ConfHelper.Update 的副本上调用
+func (i *ChatGptConf) useEvalMockServer(url string) {
+	i.Provider = &ProviderConf{BaseURL: url}
+	i.Token, i.TokenFile, i.TokenEnv, i.Tokens = evalMockToken, "", "", nil
+	i.Backends = nil
+	i.Fallbacks = nil
+	for _, profile := range i.ModelProfiles {
+		profile.Fallbacks = nil
+	}
+}
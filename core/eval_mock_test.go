@@ -0,0 +1,63 @@
+package core
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+)
+
+// TestEvalMockServerReply 相同消息的 mock 回复按顺序出队, 未填写的也占一个位置, 块标量的末尾换行不影响匹配
+func TestEvalMockServerReply(t *testing.T) {
+	fail := false
+	suite := &EvalSuite{Cases: []*EvalCase{{Turns: []*EvalTurn{
+		{User: "你好\n", Mock: "first"},
+		{User: "你好"},
+		{User: "你好", Mock: "third", JudgeMock: &fail, Assert: []*EvalAssertion{{Rubric: "礼貌"}}},
+	}}}}
+	server, err := startEvalMockServer(suite, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	user := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "你好"}}
+	for _, want := range []string{"first", "mock: 你好", "third", "mock: 你好"} {
+		if got := server.reply(user); got != want {
+			t.Fatalf("reply = %q, want %q", got, want)
+		}
+	}
+
+	judge := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: evalJudgePrompt},
+		{Role: openai.ChatMessageRoleUser, Content: "评分标准:\n礼貌" + evalJudgeQuestion + "你好" + evalJudgeAnswer + "third"},
+	}
+	if got := server.reply(judge); !strings.Contains(got, `"pass": false`) {
+		t.Fatalf("judge reply = %s, want fail", got)
+	}
+	if got := server.reply(judge); !strings.Contains(got, `"pass": true`) {
+		t.Fatalf("judge reply after verdicts exhausted = %s, want pass", got)
+	}
+}
+
+// TestEvalMockServerReportsServeError 服务异常退出时 Close 返回退出原因
+func TestEvalMockServerReportsServeError(t *testing.T) {
+	server, err := startEvalMockServer(&EvalSuite{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.listener.Close()
+	served := <-server.served
+	server.served <- served
+	if err := server.Close(); err == nil {
+		t.Fatal("监听关闭导致服务退出时 Close 应返回错误")
+	}
+
+	server, err = startEvalMockServer(&EvalSuite{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatalf("正常关闭不应返回错误: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventLogDateFormat 事件日志文件名中的日期格式, 每天一个文件
+const eventLogDateFormat = "2006-01-02"
+
+// EventLogConf 事件日志配置, 每条收到的消息记录为一行JSON, 用于审计、分析与回放
+type EventLogConf struct {
+	// Dir 事件日志目录, 为空时不记录; 文件名为 <机器人名称>-<日期>.jsonl
+	Dir string `json:"dir,omitempty"`
+	// MaxDays 保留最近几天的事件日志, 为0时不清理
+	MaxDays int `json:"max_days,omitempty"`
+	// Redact 发送者记录为哈希值, 消息、请求与回复只记录长度; 开启日志脱敏(-r)时同样生效
+	Redact bool `json:"redact,omitempty"`
+}
+
+// EventRecord 一条收到的消息及其处理过程
+type EventRecord struct {
+	Time    time.Time `json:"time"`
+	Bot     string    `json:"bot"`
+	MsgId   string    `json:"msg_id,omitempty"`
+	MsgType string    `json:"msg_type,omitempty"`
+	Sender  string    `json:"sender,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	// Content 消息原文, 群聊消息包含群聊前缀
+	Content string `json:"content"`
+	// Filtered 消息未匹配群聊过滤规则, 没有处理
+	Filtered bool `json:"filtered,omitempty"`
+	// Request buildCompletionRequest 构建的模型请求, 不需要请求模型时为空
+	Request *openai.ChatCompletionRequest `json:"request,omitempty"`
+	// Response 模型返回的完整结果
+	Response *openai.ChatCompletionResponse `json:"response,omitempty"`
+	// Model 实际使用的模型, 使用备用模型时与请求中的不同
+	Model string `json:"model,omitempty"`
+	// Reply 回复的内容
+	Reply string `json:"reply,omitempty"`
+	Error string `json:"error,omitempty"`
+	// LatencyMs 模型请求耗时
+	LatencyMs int64 `json:"latency_ms,omitempty"`
+	// DurationMs 处理消息的总耗时
+	DurationMs int64 `json:"duration_ms"`
+	// Redacted 记录已脱敏, 发送者为哈希值, 消息与回复只有长度
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// redacted 脱敏后的副本: 发送者替换为哈希值, 消息与回复只保留长度, 不记录完整的模型请求与结果
+func (r *EventRecord) redacted() *EventRecord {
+	record := *r
+	record.Sender = hashSender(r.Sender)
+	record.Content = summarizeBody(r.Content)
+	record.Reply = summarizeBody(r.Reply)
+	record.Request, record.Response = nil, nil
+	record.Redacted = true
+	return &record
+}
+
+// summarizedBodyPattern summarizeBody 生成的内容
+var summarizedBodyPattern = regexp.MustCompile(`^\*\*\*\(\d+ chars\)$`)
+
+// isRedacted 记录是否已脱敏, 没有 redacted 字段的旧记录按发送者哈希值与内容格式判断
+func (r *EventRecord) isRedacted() bool {
+	if r.Redacted {
+		return true
+	}
+	return strings.HasPrefix(r.Sender, "sha256:") && summarizedBodyPattern.MatchString(r.Content)
+}
+
+// ReadEventRecords 读取事件日志, 跳过空行
+func ReadEventRecords(file string) ([]*EventRecord, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*EventRecord, 0)
+	for idx, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record := &EventRecord{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			return nil, errors.Wrapf(err, "%s 第 %d 行格式错误", file, idx+1)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// eventLog 追加写入事件日志, 按天切换文件
+type eventLog struct {
+	bot  string
+	file *os.File
+	// path 当前打开的文件路径, 日期或目录变化时重新打开
+	path string
+	mu   sync.Mutex
+}
+
+func newEventLog(bot string) *eventLog {
+	return &eventLog{bot: bot}
+}
+
+// eventLogEnabled 是否记录事件日志
+func (b *BotInstance) eventLogEnabled() bool {
+	conf := b.Conf().EventLog
+	return conf != nil && conf.Dir != ""
+}
+
+// writeEvent 记录一条消息的处理过程, 写入失败只记录日志, 不影响消息处理
+func (b *BotInstance) writeEvent(record *EventRecord) {
+	if !b.eventLogEnabled() {
+		return
+	}
+	conf := b.Conf().EventLog
+	if redactEnabled(conf.Redact) {
+		record = record.redacted()
+	}
+	if err := b.events.write(conf, record); err != nil {
+		b.logger.Warn("写入事件日志失败: " + err.Error())
+	}
+}
+
+func (l *eventLog) write(conf *EventLogConf, record *EventRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	path := filepath.Join(conf.Dir, fmt.Sprintf("%s-%s.jsonl", l.bot, record.Time.Format(eventLogDateFormat)))
+	if path != l.path {
+		if err := l.rotate(conf, path); err != nil {
+			return err
+		}
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// rotate 关闭当前文件并打开新的文件, 同时清理过期的事件日志
+func (l *eventLog) rotate(conf *EventLogConf, path string) error {
+	if l.file != nil {
+		l.file.Close()
+		l.file, l.path = nil, ""
+	}
+	if err := os.MkdirAll(conf.Dir, 0700); err != nil {
+		return errors.Wrap(err, "创建事件日志目录失败")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	l.file, l.path = file, path
+	if conf.MaxDays > 0 {
+		l.cleanup(conf)
+	}
+	return nil
+}
+
+// cleanup 删除超过保留天数的事件日志, 日期从文件名中解析
+func (l *eventLog) cleanup(conf *EventLogConf) {
+	files, err := filepath.Glob(filepath.Join(conf.Dir, l.bot+"-*.jsonl"))
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+	expire := time.Now().AddDate(0, 0, -conf.MaxDays).Format(eventLogDateFormat)
+	for _, file := range files {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), l.bot+"-"), ".jsonl")
+		if _, err := time.Parse(eventLogDateFormat, date); err != nil || date >= expire {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			ComponentLogger(LogComponentWechat).Warn("清理事件日志失败: " + err.Error())
+		}
+	}
+}
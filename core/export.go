@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 导出格式
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatJSON     = "json"
+	ExportFormatHTML     = "html"
+)
+
+// exportTimeFormat 导出文件中的时间格式
+const exportTimeFormat = "2006-01-02 15:04:05"
+
+// Transcript 导出的对话记录
+type Transcript struct {
+	Title      string    `json:"title"`
+	ExportedAt time.Time `json:"exported_at"`
+	// Notice 导出内容不完整时的说明, 例如部分事件日志已脱敏
+	Notice        string                    `json:"notice,omitempty"`
+	Conversations []*TranscriptConversation `json:"conversations"`
+}
+
+// errExportRedacted 事件日志中的问答都已脱敏, 无法导出原文
+var errExportRedacted = errors.New("事件日志已脱敏(event_log.redact 或 -r), 无法导出问答原文")
+
+// SetEventConversations 从事件日志整理对话, 已脱敏的问答不导出并在 Notice 中说明, 全部已脱敏时返回错误
+func (t *Transcript) SetEventConversations(records []*EventRecord, group, sender string) error {
+	conversations, redacted := TranscriptFromEvents(records, group, sender)
+	if redacted > 0 {
+		if len(conversations) == 0 {
+			return errExportRedacted
+		}
+		t.Notice = fmt.Sprintf("有 %d 条问答在事件日志中已脱敏(event_log.redact 或 -r), 未包含在导出中", redacted)
+	}
+	t.Conversations = conversations
+	return nil
+}
+
+// TranscriptConversation 一个发送者的对话
+type TranscriptConversation struct {
+	Sender   string               `json:"sender"`
+	Group    string               `json:"group,omitempty"`
+	Messages []*TranscriptMessage `json:"messages"`
+}
+
+// TranscriptMessage 对话中的一条消息, 默认提示没有时间
+type TranscriptMessage struct {
+	Time    *time.Time `json:"time,omitempty"`
+	Role    string     `json:"role"`
+	Content string     `json:"content"`
+}
+
+// TimeString 格式化的消息时间, 没有时间时为空字符串
+func (m *TranscriptMessage) TimeString() string {
+	if m.Time == nil {
+		return ""
+	}
+	return m.Time.Format(exportTimeFormat)
+}
+
+// ValidExportFormat 是否为支持的导出格式
+func ValidExportFormat(format string) bool {
+	switch format {
+	case ExportFormatMarkdown, ExportFormatJSON, ExportFormatHTML:
+		return true
+	}
+	return false
+}
+
+// Render 按格式输出对话记录
+func (t *Transcript) Render(format string) ([]byte, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return t.renderMarkdown(), nil
+	case ExportFormatJSON:
+		return json.MarshalIndent(t, "", "  ")
+	case ExportFormatHTML:
+		buf := bytes.Buffer{}
+		if err := transcriptHTMLTemplate.Execute(&buf, t); err != nil {
+			return nil, errors.Wrap(err, "生成HTML失败")
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("不支持的导出格式: %s, 可选 md、json、html", format)
+}
+
+func (t *Transcript) renderMarkdown() []byte {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("# %s\n\n导出时间: %s\n", t.Title, t.ExportedAt.Format(exportTimeFormat)))
+	if t.Notice != "" {
+		sb.WriteString("\n> " + t.Notice + "\n")
+	}
+	for _, conversation := range t.Conversations {
+		sb.WriteString("\n## " + conversation.Sender)
+		if conversation.Group != "" {
+			sb.WriteString(" @ " + conversation.Group)
+		}
+		sb.WriteString("\n")
+		for _, message := range conversation.Messages {
+			sb.WriteString("\n**" + message.Role + "**")
+			if message.Time != nil {
+				sb.WriteString(" " + message.TimeString())
+			}
+			sb.WriteString("\n\n" + message.Content + "\n")
+		}
+	}
+	return []byte(sb.String())
+}
+
+var transcriptHTMLTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 860px; margin: 24px auto; color: #222; }
+.message { margin: 8px 0; padding: 8px 12px; border-radius: 6px; background: #f4f4f4; white-space: pre-wrap; }
+.user { background: #e8f0fe; }
+.assistant { background: #e6f4ea; }
+.meta { font-size: 12px; color: #666; }
+.notice { padding: 8px 12px; border-radius: 6px; background: #fef7e0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">导出时间: {{.ExportedAt.Format "2006-01-02 15:04:05"}}</p>
+{{if .Notice}}<p class="notice">{{.Notice}}</p>{{end}}
+{{range .Conversations}}
+<h2>{{.Sender}}{{if .Group}} @ {{.Group}}{{end}}</h2>
+{{range .Messages}}<div class="message {{.Role}}"><div class="meta">{{.Role}} {{.TimeString}}</div>{{.Content}}</div>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// Transcript 导出发送者当前有效的对话, 时间取自消息的 Timestamp
+func (u *ChatContext) Transcript(key string) *TranscriptConversation {
+	u.RLock()
+	defer u.RUnlock()
+	values := u.items[key]
+	conversation := &TranscriptConversation{Sender: key, Messages: make([]*TranscriptMessage, 0)}
+	for _, value := range values.GetValidMessages(u.confHelper.ConversationTimeout(), time.Now()) {
+		message := &TranscriptMessage{Role: value.Role, Content: value.Content}
+		// 默认提示的时间戳为固定的最大值, 不是真实时间
+		if value.Role != openai.ChatMessageRoleSystem {
+			timestamp := time.Unix(int64(value.Timestamp), 0)
+			message.Time = &timestamp
+		}
+		conversation.Messages = append(conversation.Messages, message)
+	}
+	return conversation
+}
+
+// ReadEventRange 读取机器人在日期范围内的事件日志, 没有事件日志的日期跳过
+func ReadEventRange(dir, bot string, from, to time.Time) ([]*EventRecord, error) {
+	records := make([]*EventRecord, 0)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", bot, day.Format(eventLogDateFormat)))
+		dayRecords, err := ReadEventRecords(file)
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, dayRecords...)
+	}
+	return records, nil
+}
+
+// TranscriptFromEvents 按群聊与发送者整理事件日志中的问答, group、sender 为空时不过滤
+// 被过滤与没有回复的消息不导出; sender 可以是完整的发送者名称或昵称
+// 已脱敏的问答只有长度, 也不导出, 返回群聊中跳过的已脱敏问答数(发送者为哈希值, 无法按 sender 过滤)
+func TranscriptFromEvents(records []*EventRecord, group, sender string) ([]*TranscriptConversation, int) {
+	conversations := make([]*TranscriptConversation, 0)
+	index := make(map[string]*TranscriptConversation)
+	redacted := 0
+	for _, record := range records {
+		if record.Filtered || record.Reply == "" {
+			continue
+		}
+		if group != "" && record.Group != group {
+			continue
+		}
+		if record.isRedacted() {
+			redacted++
+			continue
+		}
+		if sender != "" && record.Sender != sender && senderNickName(record.Sender) != sender {
+			continue
+		}
+		key := record.Group + "\n" + record.Sender
+		conversation, ok := index[key]
+		if !ok {
+			conversation = &TranscriptConversation{Sender: record.Sender, Group: record.Group}
+			index[key] = conversation
+			conversations = append(conversations, conversation)
+		}
+		asked := record.Time
+		replied := record.Time.Add(time.Duration(record.DurationMs) * time.Millisecond)
+		conversation.Messages = append(conversation.Messages,
+			&TranscriptMessage{Time: &asked, Role: openai.ChatMessageRoleUser, Content: record.Content},
+			&TranscriptMessage{Time: &replied, Role: openai.ChatMessageRoleAssistant, Content: record.Reply},
+		)
+	}
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].Group < conversations[j].Group
+	})
+	return conversations, redacted
+}
+
+// exportDayRange 最近几天的日期范围, 包括今天
+func exportDayRange(days int) (time.Time, time.Time) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, 1-days), today
+}
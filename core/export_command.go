@@ -0,0 +1,214 @@
+package core
+
+import (
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxExportDays 聊天中导出群聊对话时最多导出的天数
+const maxExportDays = 31
+
+var ExportCommand = &cobra.Command{
+	Use:   "export",
+	Short: "从事件日志导出对话记录",
+	Long:  fmt.Sprintf("按群聊、发送者与日期范围导出事件日志中的问答, 支持 md、json、html 格式"),
+	Args:  cobra.NoArgs,
+	RunE:  exportTranscript,
+}
+
+var (
+	exportDir    string
+	exportBot    string
+	exportGroup  string
+	exportSender string
+	exportFrom   string
+	exportTo     string
+	exportFormat string
+	exportOutput string
+)
+
+func init() {
+	ExportCommand.Flags().StringVarP(&configFile, "configFile", "c", "chatgpt.json", "-c chatgpt.json")
+	ExportCommand.Flags().StringVar(&exportDir, "dir", "", "--dir events 事件日志目录, 默认使用配置中的 event_log.dir")
+	ExportCommand.Flags().StringVar(&exportBot, "bot", DefaultBotName, "--bot default 机器人名称")
+	ExportCommand.Flags().StringVarP(&exportGroup, "group", "g", "", "--group 群聊名称 只导出该群聊")
+	ExportCommand.Flags().StringVar(&exportSender, "sender", "", "--sender 昵称 只导出该发送者")
+	ExportCommand.Flags().StringVar(&exportFrom, "from", "", "--from 2006-01-02 开始日期, 默认今天")
+	ExportCommand.Flags().StringVar(&exportTo, "to", "", "--to 2006-01-02 结束日期, 默认今天")
+	ExportCommand.Flags().StringVarP(&exportFormat, "format", "f", ExportFormatMarkdown, "--format md|json|html")
+	ExportCommand.Flags().StringVarP(&exportOutput, "output", "o", "", "-o chat.md 写入文件, 默认输出到标准输出")
+}
+
+func exportTranscript(cmd *cobra.Command, args []string) error {
+	if !ValidExportFormat(exportFormat) {
+		return fmt.Errorf("不支持的导出格式: %s, 可选 md、json、html", exportFormat)
+	}
+	dir := exportDir
+	if dir == "" {
+		helper := NewConfHelper(configFile)
+		conf, err := helper.LoadConf()
+		if err != nil {
+			return err
+		}
+		if conf.EventLog == nil || conf.EventLog.Dir == "" {
+			return errors.New("配置中没有 event_log.dir, 请使用 --dir 指定事件日志目录")
+		}
+		dir = conf.EventLog.Dir
+	}
+	from, to := exportDayRange(1)
+	var err error
+	if exportFrom != "" {
+		if from, err = time.ParseInLocation(eventLogDateFormat, exportFrom, time.Local); err != nil {
+			return errors.Wrap(err, "--from 日期格式错误")
+		}
+	}
+	if exportTo != "" {
+		if to, err = time.ParseInLocation(eventLogDateFormat, exportTo, time.Local); err != nil {
+			return errors.Wrap(err, "--to 日期格式错误")
+		}
+	}
+	if to.Before(from) {
+		return errors.New("--to 不能早于 --from")
+	}
+
+	records, err := ReadEventRange(dir, exportBot, from, to)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("对话记录 %s ~ %s", from.Format(eventLogDateFormat), to.Format(eventLogDateFormat))
+	if exportGroup != "" {
+		title = exportGroup + " " + title
+	}
+	transcript := &Transcript{Title: title, ExportedAt: time.Now()}
+	if err := transcript.SetEventConversations(records, exportGroup, exportSender); err != nil {
+		return err
+	}
+	if transcript.Notice != "" {
+		fmt.Fprintln(cmd.ErrOrStderr(), transcript.Notice)
+	}
+	data, err := transcript.Render(exportFormat)
+	if err != nil {
+		return err
+	}
+	if exportOutput == "" {
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	}
+	return os.WriteFile(exportOutput, data, 0600)
+}
+
+// handleExportCommand 处理聊天中的导出命令, 导出的文件发送回当前聊天, 返回回复的消息、命令本身的错误与发送回复的错误
+// admin export [md|json|html] 导出发送者当前的对话, 所有人可用;
+// admin export group [天数] [md|json|html] 从事件日志导出当前群聊最近几天所有人的对话, 只有管理员可用
+// 每次导出(包括被拒绝和失败的)都记录审计日志
+func (h MessageHandler) handleExportCommand(msg *openwechat.Message, msgContent, senderName string,
+) (sent *openwechat.SentMessage, commandErr error, err error) {
+	admin := h.adminFor(msg, nil)
+	tokens := strings.Fields(msgContent)
+	entry := &AdminAuditEntry{
+		Time:    time.Now(),
+		Bot:     h.instance.Name,
+		Actor:   senderName,
+		Group:   admin.group,
+		Command: "export",
+		Args:    tokens[2:],
+		Result:  AdminAuditResultOk,
+	}
+	defer func() {
+		if commandErr != nil {
+			entry.Result, entry.Error = AdminAuditResultError, commandErr.Error()
+		}
+		admin.RecordAudit(entry)
+	}()
+
+	name, data, commandErr := h.exportTranscript(msg, tokens[2:], senderName)
+	if commandErr != nil {
+		sent, err = msg.ReplyText(commandErr.Error())
+		return sent, commandErr, err
+	}
+	sent, err = replyExportFile(msg, name, data)
+	if err != nil {
+		commandErr = errors.WithMessage(err, "发送导出文件失败")
+	}
+	return sent, commandErr, err
+}
+
+// exportTranscript 按导出命令的参数生成导出文件, 返回文件名与内容
+func (h MessageHandler) exportTranscript(msg *openwechat.Message, args []string, senderName string,
+) (string, []byte, error) {
+	format, days, groupMode := ExportFormatMarkdown, 1, false
+	for _, token := range args {
+		if token == "group" {
+			groupMode = true
+		} else if ValidExportFormat(token) {
+			format = token
+		} else if value, err := strconv.Atoi(token); err == nil && value > 0 && value <= maxExportDays {
+			days = value
+		} else {
+			return "", nil, fmt.Errorf("export command format error: admin export [group [1-%d]] [md|json|html]",
+				maxExportDays)
+		}
+	}
+
+	transcript := &Transcript{ExportedAt: time.Now()}
+	if groupMode {
+		groupName := h.GetGroupName(msg)
+		if groupName == "" {
+			return "", nil, errors.New("admin export group 只能在群聊中使用")
+		}
+		if !h.isAdmin(msg) {
+			h.instance.componentLogger(LogComponentAdmin).Warn("拒绝非管理员导出群聊对话", logSender(senderName),
+				zap.String("group", groupName))
+			return "", nil, errAdminForbidden
+		}
+		conf := h.confHelper.GetConf().EventLog
+		if conf == nil || conf.Dir == "" {
+			return "", nil, errors.New("未配置事件日志, 无法导出群聊对话")
+		}
+		from, to := exportDayRange(days)
+		records, err := ReadEventRange(conf.Dir, h.instance.Name, from, to)
+		if err != nil {
+			return "", nil, errors.WithMessage(err, "读取事件日志失败")
+		}
+		transcript.Title = fmt.Sprintf("%s 对话记录 %s ~ %s", groupName,
+			from.Format(eventLogDateFormat), to.Format(eventLogDateFormat))
+		if err := transcript.SetEventConversations(records, groupName, ""); err != nil {
+			return "", nil, err
+		}
+	} else {
+		transcript.Title = "对话记录 " + senderName
+		transcript.Conversations = []*TranscriptConversation{h.chatContext.Transcript(senderName)}
+	}
+	data, err := transcript.Render(format)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("chat-%s.%s", transcript.ExportedAt.Format("20060102-150405"), format), data, nil
+}
+
+// replyExportFile 写入临时文件后作为文件回复, 文件名取自临时文件
+func replyExportFile(msg *openwechat.Message, name string, data []byte) (*openwechat.SentMessage, error) {
+	dir, err := os.MkdirTemp("", "chatgpt-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return msg.ReplyFile(file)
+}
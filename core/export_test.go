@@ -0,0 +1,38 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTranscriptSkipsRedactedEvents 已脱敏的问答不导出, 在导出文件中注明, 全部已脱敏时返回错误
+func TestTranscriptSkipsRedactedEvents(t *testing.T) {
+	now := time.Now()
+	plain := &EventRecord{Time: now, Group: "g", Sender: "Person:a(1)", Content: "hello", Reply: "world"}
+	legacy := &EventRecord{Time: now, Group: "g", Sender: hashSender("Person:b(2)"), Content: "***(2 chars)", Reply: "***(3 chars)"}
+	records := []*EventRecord{plain, plain.redacted(), legacy}
+
+	transcript := &Transcript{Title: "test", ExportedAt: now}
+	if err := transcript.SetEventConversations(records, "g", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(transcript.Conversations) != 1 || len(transcript.Conversations[0].Messages) != 2 {
+		t.Fatalf("conversations = %+v", transcript.Conversations)
+	}
+	if !strings.Contains(transcript.Notice, "2 条") {
+		t.Fatalf("notice = %q", transcript.Notice)
+	}
+	for _, format := range []string{ExportFormatMarkdown, ExportFormatHTML, ExportFormatJSON} {
+		data, err := transcript.Render(format)
+		if err != nil || !strings.Contains(string(data), transcript.Notice) {
+			t.Fatalf("%s 导出应包含说明, err = %v", format, err)
+		}
+	}
+
+	err := (&Transcript{}).SetEventConversations(records[1:], "g", "")
+	if !errors.Is(err, errExportRedacted) {
+		t.Fatalf("err = %v, want errExportRedacted", err)
+	}
+}
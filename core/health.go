@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/eatmoreapple/openwechat"
+	"net/http"
+	"time"
+)
+
+const defaultSyncTimeoutSeconds = 300
+
+// 微信登录状态
+const (
+	loginStatePending = "pending"
+	loginStateOnline  = "online"
+	loginStateOffline = "offline"
+	// loginStateReconnecting 掉线后正在自动重新登录
+	loginStateReconnecting = "reconnecting"
+)
+
+// recordSyncCheck 记录成功的消息同步, 注册为 openwechat.Bot 的心跳回调
+func (b *BotInstance) recordSyncCheck(resp openwechat.SyncCheckResponse) {
+	if resp.Success() {
+		b.lastSyncAt.Store(time.Now().Unix())
+	}
+}
+
+// HealthStatus 单个机器人实例的健康检查结果
+type HealthStatus struct {
+	Bot          string `json:"bot"`
+	Status       string `json:"status"`
+	LoginState   string `json:"login_state"`
+	LastSyncAt   string `json:"last_sync_at,omitempty"`
+	SyncAge      int64  `json:"sync_age_seconds,omitempty"`
+	LLMReachable bool   `json:"llm_reachable"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// HealthReport 所有机器人实例的健康检查结果, 任一实例不健康时整体不健康
+type HealthReport struct {
+	Status string          `json:"status"`
+	Bots   []*HealthStatus `json:"bots"`
+}
+
+// loginState 获取当前微信登录状态
+func (b *BotInstance) loginState() string {
+	if b.online() {
+		return loginStateOnline
+	}
+	if b.reconnecting.Load() {
+		return loginStateReconnecting
+	}
+	if b.everLoggedIn.Load() {
+		return loginStateOffline
+	}
+	return loginStatePending
+}
+
+// checkHealth 检查健康状态
+// liveness: 登录后掉线或长时间没有成功同步视为不健康, 等待扫码与自动重新登录期间视为健康, 避免被反复重启
+// readiness: 需要在线、同步正常且至少有一个模型后端可用
+func (b *BotInstance) checkHealth(readiness bool, syncTimeout int64) *HealthStatus {
+	status := &HealthStatus{
+		Bot:          b.Name,
+		LoginState:   b.loginState(),
+		LLMReachable: b.handler.backends != nil && b.handler.backends.Reachable(),
+	}
+	if last := b.lastSyncAt.Load(); last > 0 {
+		status.LastSyncAt = time.Unix(last, 0).Format(TimeFormat)
+		status.SyncAge = time.Now().Unix() - last
+	}
+
+	switch {
+	case status.LoginState == loginStateOffline:
+		status.Reason = "微信已掉线"
+	case status.LoginState == loginStateOnline && status.SyncAge > syncTimeout:
+		status.Reason = "长时间没有成功同步消息"
+	case readiness && status.LoginState == loginStatePending:
+		status.Reason = "等待扫码登录"
+	case readiness && status.LoginState == loginStateReconnecting:
+		status.Reason = "正在重新登录"
+	case readiness && !status.LLMReachable:
+		status.Reason = "没有可用的模型后端"
+	}
+	status.Status = "ok"
+	if status.Reason != "" {
+		status.Status = "unavailable"
+	}
+	return status
+}
+
+// checkHealth 检查所有机器人实例的健康状态
+func (m *BotManager) checkHealth(readiness bool) (*HealthReport, bool) {
+	syncTimeout := int64(defaultSyncTimeoutSeconds)
+	if conf := m.httpConf(); conf != nil && conf.SyncTimeoutSeconds > 0 {
+		syncTimeout = int64(conf.SyncTimeoutSeconds)
+	}
+	report := &HealthReport{Status: "ok"}
+	for _, instance := range m.instances {
+		status := instance.checkHealth(readiness, syncTimeout)
+		if status.Reason != "" {
+			report.Status = "unavailable"
+		}
+		report.Bots = append(report.Bots, status)
+	}
+	return report, report.Status == "ok"
+}
+
+func healthHandler(manager *BotManager, readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, healthy := manager.checkHealth(readiness)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// HTTP服务的超时, 避免慢速或空闲连接长期占用
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = 30 * time.Second
+	httpWriteTimeout      = 60 * time.Second
+	httpIdleTimeout       = 120 * time.Second
+)
+
+// HttpConf 内置HTTP服务配置, listen 为空时不启动
+type HttpConf struct {
+	Listen string `json:"listen,omitempty"`
+	// SyncTimeoutSeconds 超过该时间没有成功同步消息时健康检查失败, 默认300秒
+	SyncTimeoutSeconds int `json:"sync_timeout_seconds,omitempty"`
+	// AdminToken 管理接口 /api/ 的访问令牌, 为空时不开放管理接口
+	AdminToken string `json:"admin_token,omitempty" secret:"true"`
+	// SendToken 主动发送消息接口 /send 的访问令牌, admin_token 也可以访问
+	SendToken string `json:"send_token,omitempty" secret:"true"`
+}
+
+// startHttpServer 启动内置HTTP服务, 提供 /metrics、/healthz、/readyz、/api/、/send 等接口, 根路径为内置管理页面
+// 多账号模式下所有机器人实例共用一个HTTP服务
+func startHttpServer(manager *BotManager) {
+	conf := manager.httpConf()
+	if conf == nil || conf.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", healthHandler(manager, false))
+	mux.Handle("/readyz", healthHandler(manager, true))
+	mux.Handle(adminApiPrefix, adminApiHandler{manager: manager})
+	mux.Handle(sendApiPath, sendApiHandler{manager: manager})
+	mux.Handle("/", dashboardHandler())
+
+	server := &http.Server{
+		Addr:              conf.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	go func() {
+		Logger.Info("HTTP服务监听: " + conf.Listen)
+		if err := server.ListenAndServe(); err != nil {
+			Logger.Error("HTTP服务退出: " + err.Error())
+		}
+	}()
+}
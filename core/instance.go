@@ -0,0 +1,289 @@
+package core
+
+import (
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBotName 单账号模式下机器人实例的名称
+const DefaultBotName = "default"
+
+// BotInstance 一个微信账号的机器人实例, 拥有独立的配置、登录信息、对话上下文与日志字段
+type BotInstance struct {
+	Name       string
+	confHelper *ConfHelper
+	handler    MessageHandler
+	// logger 微信组件的日志, 带有机器人名称字段
+	logger *zap.Logger
+	// loginStorageFile 未配置 login.storage 时使用的登录信息文件
+	loginStorageFile string
+	// webhooks 外发事件
+	webhooks *webhookDispatcher
+	// sendLimiter 主动发送消息的限流
+	sendLimiter *sendRateLimiter
+	// events 事件日志
+	events *eventLog
+
+	// bot 当前的微信机器人, 供通知、指标等在消息处理之外访问
+	bot atomic.Pointer[openwechat.Bot]
+	// lastSyncAt 最近一次成功同步消息的时间戳
+	lastSyncAt atomic.Int64
+	// everLoggedIn 是否已经登录过, 用于区分等待扫码与登录后掉线
+	everLoggedIn atomic.Bool
+	// reconnecting 掉线后是否正在自动重新登录
+	reconnecting atomic.Bool
+	// loggedOut 是否通过管理接口主动退出登录, 主动退出后不再自动重新登录
+	loggedOut atomic.Bool
+	// loginQrcodeUrl 等待扫码时的登录二维码地址, 登录成功后清空
+	loginQrcodeUrl atomic.Pointer[string]
+}
+
+// NewBotInstance 加载配置并创建机器人实例, 使用 envPrefix 开头的环境变量覆盖配置, 需在 InitLogger 之后调用
+func NewBotInstance(name, configFile, loginStorageFile, envPrefix string) (*BotInstance, error) {
+	b := &BotInstance{
+		Name:             name,
+		confHelper:       NewBotConfHelper(configFile, envPrefix),
+		logger:           ComponentLogger(LogComponentWechat).With(zap.String("bot", name)),
+		loginStorageFile: loginStorageFile,
+		webhooks:         newWebhookDispatcher(),
+		sendLimiter:      newSendRateLimiter(),
+		events:           newEventLog(name),
+	}
+	if _, err := b.confHelper.LoadConf(); err != nil {
+		return nil, errors.WithMessagef(err, "机器人 %s 加载配置 %s 失败", name, configFile)
+	}
+	backends, err := NewLLMBackends(b.confHelper.GetConf(), b.onBreakerStateChange)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "机器人 %s 创建模型后端失败", name)
+	}
+	// 重新加载、回滚或替换配置时, 后端相关配置的变化随配置一起生效
+	b.confHelper.OnPublish(backends.prepareReload)
+	b.handler = MessageHandler{
+		instance:    b,
+		confHelper:  b.confHelper,
+		logger:      b.logger,
+		backends:    backends,
+		completer:   backends,
+		chatContext: NewChatContext(b.confHelper, b.componentLogger(LogComponentContext)),
+		usage:       NewUsageTracker(),
+		activity:    NewGroupActivity(),
+	}
+	b.registerMetrics()
+	return b, nil
+}
+
+// componentLogger 获取组件的日志, 带有机器人名称字段
+func (b *BotInstance) componentLogger(component string) *zap.Logger {
+	return ComponentLogger(component).With(zap.String("bot", b.Name))
+}
+
+// Conf 获取当前配置快照
+func (b *BotInstance) Conf() *ChatGptConf {
+	return b.confHelper.GetConf()
+}
+
+// registerMetrics 注册实例级别的指标, 以 bot 标签区分
+func (b *BotInstance) registerMetrics() {
+	labels := prometheus.Labels{"bot": b.Name}
+	metricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "chatgpt_bot_active_conversations",
+			Help:        "对话上下文中仍在有效期内的会话数",
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(b.handler.chatContext.ActiveCount())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "chatgpt_bot_wechat_logged_in",
+			Help:        "微信登录状态, 1为在线",
+			ConstLabels: labels,
+		}, func() float64 {
+			if b.online() {
+				return 1
+			}
+			return 0
+		}),
+	)
+}
+
+// online 微信是否在线
+func (b *BotInstance) online() bool {
+	bot := b.bot.Load()
+	return bot != nil && bot.Alive()
+}
+
+// BotManager 同一进程中运行的所有机器人实例
+type BotManager struct {
+	instances []*BotInstance
+	// httpConf 内置HTTP服务配置, 单账号模式下随机器人配置重新加载
+	httpConf func() *HttpConf
+}
+
+// NewSingleBotManager 单账号模式, 使用一个配置文件
+func NewSingleBotManager(configFile string) (*BotManager, error) {
+	instance, err := NewBotInstance(DefaultBotName, configFile, defaultLoginStorageFile, EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &BotManager{
+		instances: []*BotInstance{instance},
+		httpConf: func() *HttpConf {
+			return instance.Conf().Http
+		},
+	}, nil
+}
+
+// NewMultiBotManager 多账号模式, 从多账号配置文件创建所有机器人实例
+func NewMultiBotManager(file string) (*BotManager, error) {
+	conf, err := LoadMultiBotConf(file)
+	if err != nil {
+		return nil, err
+	}
+	manager := &BotManager{
+		httpConf: func() *HttpConf {
+			return conf.Http
+		},
+	}
+	for _, botConf := range conf.Bots {
+		instance, err := NewBotInstance(botConf.Name, botConf.ConfigFile, botConf.loginStorageFile(), BotEnvPrefix(botConf.Name))
+		if err != nil {
+			return nil, err
+		}
+		manager.instances = append(manager.instances, instance)
+	}
+	return manager, nil
+}
+
+// Instances 获取所有机器人实例
+func (m *BotManager) Instances() []*BotInstance {
+	return m.instances
+}
+
+// Get 按名称获取机器人实例, 名称为空时返回第一个实例
+func (m *BotManager) Get(name string) (*BotInstance, bool) {
+	if name == "" {
+		return m.instances[0], true
+	}
+	for _, instance := range m.instances {
+		if instance.Name == name {
+			return instance, true
+		}
+	}
+	return nil, false
+}
+
+// Run 启动所有机器人实例的登录守护, 阻塞直到所有实例退出
+func (m *BotManager) Run() {
+	wg := sync.WaitGroup{}
+	for _, instance := range m.instances {
+		wg.Add(1)
+		go func(instance *BotInstance) {
+			defer wg.Done()
+			instance.supervise()
+		}(instance)
+	}
+	wg.Wait()
+}
+
+// MultiBotConf 多账号配置, 每个账号使用独立的配置文件
+type MultiBotConf struct {
+	// Http 所有账号共用的内置HTTP服务, 各账号配置文件中的 http 不生效
+	Http *HttpConf `json:"http,omitempty"`
+	// SecretKeyEnv、SecretKeyFile 解密多账号配置中 enc: 字段的密钥, 与账号配置中的同名字段含义相同
+	SecretKeyEnv  string     `json:"secret_key_env,omitempty"`
+	SecretKeyFile string     `json:"secret_key_file,omitempty"`
+	Bots          []*BotConf `json:"bots"`
+}
+
+// BotConf 单个账号的配置
+type BotConf struct {
+	Name       string `json:"name"`
+	ConfigFile string `json:"config_file"`
+}
+
+// loginStorageFile 未配置 login.storage 时使用的登录信息文件, 按账号名称区分
+func (c *BotConf) loginStorageFile() string {
+	return fmt.Sprintf(".login.storage.%s.json", c.Name)
+}
+
+// LoadMultiBotConf 加载多账号配置, 格式由文件扩展名决定, 同样支持 CHATGPT_BOT_* 环境变量覆盖
+// 各账号配置只使用 CHATGPT_BOT_<账号名称>_* 环境变量覆盖, 不受其他账号的环境变量影响
+func LoadMultiBotConf(file string) (*MultiBotConf, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	conf := &MultiBotConf{}
+	if err := unmarshalConf(DetectConfFormat(file), data, conf); err != nil {
+		return nil, errors.Wrap(err, "解析多账号配置失败")
+	}
+	if err := applyEnvToStruct(reflect.ValueOf(conf).Elem(), EnvPrefix); err != nil {
+		return nil, err
+	}
+	var key []byte
+	if err := walkSecrets(reflect.ValueOf(conf), func(value string) (string, error) {
+		if !strings.HasPrefix(value, EncryptedPrefix) {
+			return value, nil
+		}
+		if key == nil {
+			if key, err = loadSecretKey(conf.SecretKeyEnv, conf.SecretKeyFile); err != nil {
+				return "", err
+			}
+		}
+		return DecryptSecret(key, value)
+	}); err != nil {
+		return nil, err
+	}
+	return conf, conf.Validate()
+}
+
+// Validate 校验多账号配置
+func (c *MultiBotConf) Validate() error {
+	if len(c.Bots) == 0 {
+		return errors.New("多账号配置中没有机器人")
+	}
+	names := make(map[string]bool)
+	for idx, bot := range c.Bots {
+		if bot == nil || bot.Name == "" || bot.ConfigFile == "" {
+			return fmt.Errorf("bots[%d]: name 与 config_file 不能为空", idx)
+		}
+		if names[bot.Name] {
+			return fmt.Errorf("bots[%d]: 机器人名称重复: %s", idx, bot.Name)
+		}
+		names[bot.Name] = true
+	}
+	return c.validateEnvPrefixes()
+}
+
+// validateEnvPrefixes 账号的环境变量前缀不能与多账号配置的字段或其他账号的前缀重叠, 否则环境变量会被错误地应用
+func (c *MultiBotConf) validateEnvPrefixes() error {
+	type envOwner struct{ envName, owner string }
+	owners := make([]envOwner, 0, len(c.Bots)+4)
+	t := reflect.TypeOf(*c)
+	for idx := 0; idx < t.NumField(); idx++ {
+		if name := jsonFieldName(t.Field(idx)); name != "" {
+			owners = append(owners, envOwner{strings.ToUpper(name), "多账号配置字段 " + name})
+		}
+	}
+	fields := len(owners)
+	for _, bot := range c.Bots {
+		owners = append(owners, envOwner{botEnvName(bot.Name), "机器人 " + bot.Name})
+	}
+	for i := fields; i < len(owners); i++ {
+		for j := 0; j < len(owners); j++ {
+			a, b := owners[i].envName+"_", owners[j].envName+"_"
+			if i != j && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a)) {
+				return fmt.Errorf("%s 的环境变量前缀 %s 与%s冲突, 请修改机器人名称", owners[i].owner, EnvPrefix+a, owners[j].owner)
+			}
+		}
+	}
+	return nil
+}
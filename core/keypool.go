@@ -0,0 +1,324 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	KeySelectionRoundRobin = "round_robin"
+	KeySelectionLeastUsed  = "least_used"
+
+	// defaultRequestTimeout 未配置 request_timeout 时单次模型请求的超时时间
+	defaultRequestTimeout = 60 * time.Second
+)
+
+var (
+	// ErrNoAvailableKey 所有密钥都被暂时剔除
+	ErrNoAvailableKey = errors.New("没有可用的API密钥")
+
+	// 密钥被剔除的时长
+	keyEjectUnauthorized = 30 * time.Minute
+	keyEjectQuota        = time.Hour
+	keyEjectRateLimit    = time.Minute
+)
+
+// KeyConf API密钥配置, 可单独指定 base_url 与 org_id
+type KeyConf struct {
+	Token     string `json:"token,omitempty" secret:"true"`
+	TokenFile string `json:"token_file,omitempty"`
+	TokenEnv  string `json:"token_env,omitempty"`
+	BaseURL   string `json:"base_url,omitempty"`
+	OrgID     string `json:"org_id,omitempty"`
+}
+
+// pooledKey 密钥池中的密钥及其使用情况
+type pooledKey struct {
+	index   int
+	name    string
+	client  *openai.Client
+	breaker *CircuitBreaker
+
+	requests         int
+	failures         int
+	promptTokens     int
+	completionTokens int
+	lastError        string
+	ejectedUntil     time.Time
+}
+
+// available 判断密钥当前是否可用
+func (k *pooledKey) available(now time.Time) bool {
+	return !now.Before(k.ejectedUntil)
+}
+
+// KeyPool API密钥池, 按轮询或最少使用选择密钥, 并暂时剔除鉴权失败或额度耗尽的密钥
+type KeyPool struct {
+	name      string
+	keys      []*pooledKey
+	selection string
+	// timeout 单次请求的超时时间, 后端无响应时也能计为失败并触发熔断
+	timeout time.Duration
+	next    int
+	mu      sync.Mutex
+}
+
+// NewKeyPool 根据后端配置创建密钥池, token 与 tokens 中的密钥都会加入, 每个密钥有独立的熔断器
+func NewKeyPool(name string, conf *BackendConf, breakerConf *BreakerConf, listener BreakerListener) (*KeyPool, error) {
+	keyConfs := make([]*KeyConf, 0, len(conf.Tokens)+1)
+	if conf.Token != "" {
+		keyConfs = append(keyConfs, &KeyConf{Token: conf.Token})
+	}
+	keyConfs = append(keyConfs, conf.Tokens...)
+	if len(keyConfs) == 0 {
+		return nil, errors.New("未配置API密钥")
+	}
+
+	timeout := defaultRequestTimeout
+	if conf.RequestTimeout > 0 {
+		timeout = time.Duration(conf.RequestTimeout) * time.Second
+	}
+	pool := &KeyPool{name: name, selection: conf.KeySelection, timeout: timeout}
+	for idx, keyConf := range keyConfs {
+		if keyConf.Token == "" {
+			return nil, fmt.Errorf("第%d个API密钥为空", idx+1)
+		}
+		client, err := newOpenAIClient(conf.Provider, keyConf)
+		if err != nil {
+			return nil, err
+		}
+		keyName := fmt.Sprintf("#%d %s", idx+1, MaskSecret(keyConf.Token))
+		pool.keys = append(pool.keys, &pooledKey{
+			index:   idx,
+			name:    keyName,
+			client:  client,
+			breaker: NewCircuitBreaker(name+" "+keyName, breakerConf, listener),
+		})
+	}
+	return pool, nil
+}
+
+// CreateChatCompletion 选择可用密钥发起请求, 密钥被剔除时自动换下一个密钥重试
+// 所有密钥的熔断器都打开时返回 ErrCircuitOpen
+func (p *KeyPool) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	var lastErr error
+	tried := make(map[*pooledKey]bool)
+	for len(tried) < len(p.keys) {
+		key := p.pick(tried)
+		if key == nil {
+			break
+		}
+		tried[key] = true
+
+		start := time.Now()
+		resp, err := p.createChatCompletion(ctx, key, req)
+		p.observe(req.Model, time.Since(start), resp, err)
+		ejected := p.record(ctx, key, resp, err)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = errors.WithMessage(err, key.name)
+		if !ejected {
+			return resp, lastErr
+		}
+		ComponentLogger(LogComponentLLM).Warn(fmt.Sprintf("API密钥 %s 已被暂时剔除: %s", key.name, err.Error()))
+	}
+	if lastErr == nil {
+		lastErr = ErrNoAvailableKey
+		if p.circuitOpen() {
+			lastErr = ErrCircuitOpen
+		}
+	}
+	return openai.ChatCompletionResponse{}, lastErr
+}
+
+// createChatCompletion 使用指定密钥请求一次, 超过 timeout 时取消请求并返回 context.DeadlineExceeded
+func (p *KeyPool) createChatCompletion(ctx context.Context, key *pooledKey, req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return key.client.CreateChatCompletion(ctx, req)
+}
+
+// pick 选择一个未尝试过、未被剔除且熔断器放行的密钥
+// 在锁内选出候选密钥, 熔断器会回调状态变化, 在锁外询问是否放行
+func (p *KeyPool) pick(tried map[*pooledKey]bool) *pooledKey {
+	for _, key := range p.candidates(tried) {
+		if key.breaker.Allow() {
+			p.mu.Lock()
+			p.next = key.index + 1
+			p.mu.Unlock()
+			return key
+		}
+	}
+	return nil
+}
+
+// candidates 按选择策略列出未尝试过且未被剔除的密钥
+func (p *KeyPool) candidates(tried map[*pooledKey]bool) []*pooledKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	candidates := make([]*pooledKey, 0, len(p.keys))
+	for offset := 0; offset < len(p.keys); offset++ {
+		key := p.keys[(p.next+offset)%len(p.keys)]
+		if tried[key] || !key.available(now) {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if p.selection == KeySelectionLeastUsed {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].requests < candidates[j].requests
+		})
+	}
+	return candidates
+}
+
+// Reachable 是否存在未被剔除且熔断器未打开的密钥, 冷却结束的熔断器视为可用
+// 只根据本地的剔除与熔断状态判断, 不向模型服务发起探测请求
+func (p *KeyPool) Reachable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range p.keys {
+		if key.available(now) && key.breaker.State() != BreakerOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// circuitOpen 判断未被剔除的密钥是否都处于熔断状态
+func (p *KeyPool) circuitOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	open := false
+	for _, key := range p.keys {
+		if !key.available(now) {
+			continue
+		}
+		if key.breaker.State() == BreakerClosed {
+			return false
+		}
+		open = true
+	}
+	return open
+}
+
+// observe 记录请求耗时与token用量指标
+func (p *KeyPool) observe(model string, elapsed time.Duration, resp openai.ChatCompletionResponse, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metricLLMRequestDuration.WithLabelValues(p.name, model, result).Observe(elapsed.Seconds())
+	if err == nil {
+		metricLLMTokens.WithLabelValues(p.name, model, "prompt").Add(float64(resp.Usage.PromptTokens))
+		metricLLMTokens.WithLabelValues(p.name, model, "completion").Add(float64(resp.Usage.CompletionTokens))
+	}
+}
+
+// record 记录请求结果, 返回密钥是否因本次错误被剔除
+// 调用方取消或超时的请求不说明后端不可用, 不计入熔断器的成功或失败
+func (p *KeyPool) record(ctx context.Context, key *pooledKey, resp openai.ChatCompletionResponse, err error) bool {
+	// 熔断器会回调状态变化, 在锁外调用
+	switch {
+	case err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)):
+		key.breaker.Release()
+	case err != nil && isBreakerFailure(err):
+		key.breaker.Failure()
+	default:
+		key.breaker.Success()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key.requests++
+	if err == nil {
+		key.promptTokens += resp.Usage.PromptTokens
+		key.completionTokens += resp.Usage.CompletionTokens
+		return false
+	}
+	key.failures++
+	key.lastError = err.Error()
+
+	eject := keyEjectDuration(err)
+	if eject > 0 {
+		key.ejectedUntil = time.Now().Add(eject)
+		return true
+	}
+	return false
+}
+
+// keyEjectDuration 根据错误类型判断密钥需要被剔除的时长, 返回0表示不剔除
+func keyEjectDuration(err error) time.Duration {
+	statusCode, code := apiErrorStatus(err)
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return keyEjectUnauthorized
+	case http.StatusTooManyRequests:
+		if code == "insufficient_quota" {
+			return keyEjectQuota
+		}
+		return keyEjectRateLimit
+	}
+	return 0
+}
+
+// apiErrorStatus 提取OpenAI接口错误的状态码与错误码
+func apiErrorStatus(err error) (int, string) {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		code := ""
+		if apiErr.Code != nil {
+			code = *apiErr.Code
+		}
+		if code == "" {
+			code = apiErr.Type
+		}
+		return apiErr.StatusCode, code
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode, ""
+	}
+	return 0, ""
+}
+
+// Status 密钥池的使用与健康状况
+func (p *KeyPool) Status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	sb := bytes.Buffer{}
+	for _, key := range p.keys {
+		health := "healthy"
+		if !key.available(now) {
+			health = "ejected until " + key.ejectedUntil.Format(TimeFormat)
+		}
+		sb.WriteString(fmt.Sprintf("%s %s breaker:%s requests:%d failures:%d tokens:%d/%d\n",
+			key.name, health, key.breaker.State(), key.requests, key.failures, key.promptTokens, key.completionTokens))
+		if key.lastError != "" {
+			sb.WriteString("\tlast error: " + key.lastError + "\n")
+		}
+	}
+	return sb.String()
+}
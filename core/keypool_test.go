@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestKeyPool 创建不发起请求的密钥池, 只用于测试密钥选择
+func newTestKeyPool(selection string, size int) *KeyPool {
+	pool := &KeyPool{name: "test", selection: selection}
+	for idx := 0; idx < size; idx++ {
+		pool.keys = append(pool.keys, &pooledKey{
+			index:   idx,
+			name:    string(rune('a' + idx)),
+			breaker: NewCircuitBreaker("test", &BreakerConf{FailureThreshold: 1}, nil),
+		})
+	}
+	return pool
+}
+
+func pickNames(pool *KeyPool, times int) string {
+	names := ""
+	for i := 0; i < times; i++ {
+		key := pool.pick(map[*pooledKey]bool{})
+		if key == nil {
+			names += "-"
+			continue
+		}
+		names += key.name
+		key.breaker.Success()
+	}
+	return names
+}
+
+func TestKeyPoolPickRoundRobin(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionRoundRobin, 3)
+	if got := pickNames(pool, 4); got != "abca" {
+		t.Fatalf("pick = %s, want abca", got)
+	}
+}
+
+func TestKeyPoolPickLeastUsed(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionLeastUsed, 3)
+	pool.keys[0].requests = 5
+	pool.keys[1].requests = 1
+	pool.keys[2].requests = 3
+	if key := pool.pick(map[*pooledKey]bool{}); key.name != "b" {
+		t.Fatalf("pick = %s, want b", key.name)
+	}
+}
+
+func TestKeyPoolPickSkipsUnavailableKeys(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionRoundRobin, 3)
+	// a 已尝试, b 被剔除, c 熔断器打开
+	pool.keys[1].ejectedUntil = time.Now().Add(time.Minute)
+	pool.keys[2].breaker.Allow()
+	pool.keys[2].breaker.Failure()
+	if key := pool.pick(map[*pooledKey]bool{pool.keys[0]: true}); key != nil {
+		t.Fatalf("pick = %s, want nil", key.name)
+	}
+	if key := pool.pick(map[*pooledKey]bool{}); key == nil || key.name != "a" {
+		t.Fatalf("pick = %v, want a", key)
+	}
+	if !pool.Reachable() {
+		t.Fatal("a 可用, 密钥池应可达")
+	}
+}
+
+func TestKeyPoolBreakerListenerOutsideLock(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionRoundRobin, 1)
+	changes := 0
+	// 回调中访问密钥池, 在锁内回调会死锁
+	pool.keys[0].breaker = NewCircuitBreaker("test", &BreakerConf{FailureThreshold: 1, OpenSeconds: 1}, func(string, BreakerState, BreakerState) {
+		changes++
+		pool.Reachable()
+	})
+	key := pool.pick(map[*pooledKey]bool{})
+	pool.record(context.Background(), key, openai.ChatCompletionResponse{}, errors.New("connection refused"))
+	pool.keys[0].breaker.openedAt = time.Now().Add(-2 * time.Second)
+	if key := pool.pick(map[*pooledKey]bool{}); key == nil {
+		t.Fatal("冷却结束后应放行试探请求")
+	}
+	if changes != 2 {
+		t.Fatalf("changes = %d, want 2", changes)
+	}
+}
+
+func TestKeyPoolRecordIgnoresCanceledRequests(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionRoundRobin, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range []error{context.Canceled, errors.New("request aborted")} {
+		key := pool.pick(map[*pooledKey]bool{})
+		if key == nil {
+			t.Fatal("取消的请求不应打开熔断器")
+		}
+		pool.record(ctx, key, openai.ChatCompletionResponse{}, err)
+	}
+	if state := pool.keys[0].breaker.State(); state != BreakerClosed {
+		t.Fatalf("state = %s, want closed", state)
+	}
+	if isBreakerFailure(context.Canceled) {
+		t.Fatal("context.Canceled 不应计入熔断失败")
+	}
+}
+
+func TestKeyPoolReachableAfterCooldown(t *testing.T) {
+	pool := newTestKeyPool(KeySelectionRoundRobin, 1)
+	breaker := pool.keys[0].breaker
+	breaker.Allow()
+	breaker.Failure()
+	if pool.Reachable() || breaker.State() != BreakerOpen {
+		t.Fatal("冷却期间密钥池不可达")
+	}
+	breaker.openedAt = time.Now().Add(-61 * time.Second)
+	if !pool.Reachable() || breaker.State() != BreakerHalfOpen {
+		t.Fatalf("冷却结束后应视为半开, state = %s", breaker.State())
+	}
+}
+
+func TestKeyPoolTimeoutOpensBreaker(t *testing.T) {
+	// 模型接口一直不返回, 请求超时后应计为熔断失败
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	conf := &BackendConf{Provider: &ProviderConf{BaseURL: server.URL + "/v1"}, Token: "sk-test"}
+	pool, err := NewKeyPool("test", conf, &BreakerConf{FailureThreshold: 1, OpenSeconds: 60}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.timeout = 50 * time.Millisecond
+
+	_, err = pool.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if state := pool.keys[0].breaker.State(); state != BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+	if pool.Reachable() {
+		t.Fatal("超时打开熔断器后密钥池不可达")
+	}
+}
@@ -1,25 +1,81 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"strings"
 	"time"
 )
 
-var Logger *zap.Logger
+var Logger = zap.NewNop()
+
+// 日志组件, 各组件的日志级别可以在运行时分别调整
+const (
+	LogComponentWechat  = "wechat"
+	LogComponentLLM     = "llm"
+	LogComponentContext = "context"
+	LogComponentAdmin   = "admin"
+	LogComponentConfig  = "config"
+)
+
+// LogComponentRoot 不属于任何组件的日志, 即 Logger
+const LogComponentRoot = "root"
+
+// LogComponentAll 调整级别时表示根日志与所有组件
+const LogComponentAll = "all"
+
+// LogComponents 所有日志组件
+var LogComponents = []string{LogComponentWechat, LogComponentLLM, LogComponentContext, LogComponentAdmin, LogComponentConfig}
+
+// LogLevel 根日志的级别, 可在运行时调整
+var LogLevel = zap.NewAtomicLevel()
+
+// componentLevels 各组件的日志级别, 初始化时与根日志相同
+var componentLevels = func() map[string]zap.AtomicLevel {
+	levels := make(map[string]zap.AtomicLevel)
+	for _, component := range LogComponents {
+		levels[component] = zap.NewAtomicLevel()
+	}
+	return levels
+}()
+
+// componentLoggers 各组件的日志, 在 InitLogger 中创建
+var componentLoggers map[string]*zap.Logger
+
+// 日志格式
+const (
+	LogFormatConsole = "console"
+	LogFormatJson    = "json"
+)
+
+// LogOptions 日志选项
+type LogOptions struct {
+	Level string
+	File  string
+	// Format 日志格式 console(默认) 或 json
+	Format string
+	// Redact 日志脱敏, 发送者记录为哈希值, 消息内容只记录长度
+	Redact bool
+}
+
+// redactLogs 是否开启日志脱敏
+var redactLogs bool
 
 // InitLogger 初始化日志
-func InitLogger(logLevel, logFile string) {
+func InitLogger(options LogOptions) {
 	Logger, _ = zap.NewProduction()
+	redactLogs = options.Redact
 
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
 		LevelKey:       "level",
 		CallerKey:      "line",
-		NameKey:        "hydra",
+		NameKey:        "logger",
 		MessageKey:     "msg",
 		StacktraceKey:  "stacktrace",
 		LineEnding:     zapcore.DefaultLineEnding,
@@ -30,33 +86,176 @@ func InitLogger(logLevel, logFile string) {
 		EncodeName:     zapcore.FullNameEncoder,
 	}
 
-	level, err := zapcore.ParseLevel(logLevel)
+	level, err := zapcore.ParseLevel(options.Level)
 	if err != nil {
 		fmt.Println("日志级别解析失败，默认设置为info")
 		level = zapcore.InfoLevel
 	}
 
-	encoder := zapcore.NewConsoleEncoder(encoderConfig)
-	cores := make([]zapcore.Core, 0)
-	atomicLevel := zap.NewAtomicLevel()
-	atomicLevel.SetLevel(level)
-	cores = append(cores,
-		zapcore.NewCore(
-			encoder, // 编码器配置
-			zapcore.NewMultiWriteSyncer(getWriteSyncer(logFile)...), // 打印到控制台或文件或其他平台
-			atomicLevel, // 日志级别
-		),
+	var encoder zapcore.Encoder
+	switch options.Format {
+	case LogFormatJson:
+		// JSON日志由日志系统解析, 不使用颜色, 时间使用ISO8601格式
+		encoderConfig.EncodeLevel = zapcore.LowercaseLevelEncoder
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "", LogFormatConsole:
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		fmt.Println("日志格式解析失败，默认设置为console")
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	// 底层输出接受所有级别, 由根日志与各组件的级别分别过滤
+	core := zapcore.NewCore(
+		encoder, // 编码器配置
+		zapcore.NewMultiWriteSyncer(getWriteSyncer(options.File)...), // 打印到控制台或文件或其他平台
+		zap.LevelEnablerFunc(func(zapcore.Level) bool { return true }),
 	)
-
-	core := zapcore.NewTee(cores...)
 	// 开启开发模式，堆栈跟踪
 	caller := zap.AddCaller()
 	// 开启文件及行号
 	development := zap.Development()
 	// 设置初始化字段
 	filed := zap.Fields()
+
+	LogLevel.SetLevel(level)
 	// 构造日志
-	Logger = zap.New(core, caller, development, filed)
+	Logger = zap.New(levelCore{Core: core, level: LogLevel}, caller, development, filed)
+	loggers := make(map[string]*zap.Logger)
+	for component, componentLevel := range componentLevels {
+		componentLevel.SetLevel(level)
+		loggers[component] = zap.New(levelCore{Core: core, level: componentLevel}, caller, development, filed).Named(component)
+	}
+	componentLoggers = loggers
+}
+
+// levelCore 使用可调整的级别过滤日志, 根日志与各组件共用同一个输出
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// ComponentLogger 获取组件的日志, 日志名称为组件名称, 未初始化或未知组件时返回 Logger
+func ComponentLogger(component string) *zap.Logger {
+	if logger, ok := componentLoggers[component]; ok {
+		return logger
+	}
+	return Logger
+}
+
+// SetLogLevel 调整日志级别, component 为组件名称、root 或 all
+func SetLogLevel(component, level string) error {
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("不支持的日志级别: %s", level)
+	}
+	switch component {
+	case LogComponentAll:
+		LogLevel.SetLevel(parsed)
+		for _, componentLevel := range componentLevels {
+			componentLevel.SetLevel(parsed)
+		}
+	case LogComponentRoot:
+		LogLevel.SetLevel(parsed)
+	default:
+		componentLevel, ok := componentLevels[component]
+		if !ok {
+			return fmt.Errorf("不支持的日志组件: %s, 可选: %s、%s、%s", component, LogComponentRoot,
+				strings.Join(LogComponents, "、"), LogComponentAll)
+		}
+		componentLevel.SetLevel(parsed)
+	}
+	return nil
+}
+
+// LogLevels 获取根日志与各组件当前的日志级别
+func LogLevels() map[string]string {
+	levels := map[string]string{LogComponentRoot: LogLevel.Level().String()}
+	for component, componentLevel := range componentLevels {
+		levels[component] = componentLevel.Level().String()
+	}
+	return levels
+}
+
+// RedactSender 开启日志脱敏时把发送者替换为哈希值, 同一发送者的哈希值相同, 便于关联日志
+func RedactSender(sender string) string {
+	if !redactLogs {
+		return sender
+	}
+	return hashSender(sender)
+}
+
+// RedactBody 开启日志脱敏时把消息内容替换为长度
+func RedactBody(body string) string {
+	if !redactLogs {
+		return body
+	}
+	return summarizeBody(body)
+}
+
+// redactEnabled 事件日志、外发事件等输出是否脱敏: 开启了日志脱敏或该输出单独配置了 redact
+func redactEnabled(redact bool) bool {
+	return redactLogs || redact
+}
+
+// hashSender 把发送者替换为哈希值
+func hashSender(sender string) string {
+	if sender == "" {
+		return sender
+	}
+	sum := sha256.Sum256([]byte(sender))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// summarizeBody 把消息内容替换为长度
+func summarizeBody(body string) string {
+	if body == "" {
+		return body
+	}
+	return fmt.Sprintf("***(%d chars)", len([]rune(body)))
+}
+
+// logSender 发送者日志字段
+func logSender(sender string) zap.Field {
+	return zap.String("sender", RedactSender(sender))
+}
+
+// logBody 消息内容日志字段
+func logBody(key, body string) zap.Field {
+	return zap.String(key, RedactBody(body))
+}
+
+// logContexts 对话上下文日志字段, 按时间、角色、内容记录每条消息
+type logContexts ChatCompletionMessages
+
+func (c logContexts) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, message := range c {
+		message := message
+		if err := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("time", time.Unix(int64(message.Timestamp), 0).Format(TimeFormat))
+			enc.AddString("role", message.Role)
+			enc.AddString("content", RedactBody(message.Content))
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // TimeEncoder 序列化时间
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// 登录通知类型
+const (
+	LoginNotifierWebhook = "webhook"
+	LoginNotifierEmail   = "email"
+)
+
+// loginNotifyTimeout 单个登录通知的超时时间
+const loginNotifyTimeout = 10 * time.Second
+
+// LoginConf 微信登录配置
+type LoginConf struct {
+	// DisableTerminalQrcode 不在终端中渲染登录二维码, 只打印二维码地址
+	DisableTerminalQrcode bool `json:"disable_terminal_qrcode,omitempty"`
+	// QrcodeFile 登录二维码PNG图片的保存路径, 为空时不保存
+	QrcodeFile string `json:"qrcode_file,omitempty"`
+	// Notifiers 需要扫码登录或掉线时的通知方式, 便于远程登录无界面的服务器
+	Notifiers []*LoginNotifierConf `json:"notifiers,omitempty"`
+	// ReloginMinSeconds 掉线或登录失败后重新登录的初始等待时间, 默认5秒, 连续失败时翻倍
+	ReloginMinSeconds int `json:"relogin_min_seconds,omitempty"`
+	// ReloginMaxSeconds 重新登录的最长等待时间, 默认300秒
+	ReloginMaxSeconds int `json:"relogin_max_seconds,omitempty"`
+	// Storage 热登录信息的存储方式, 默认保存在 .login.storage.json
+	Storage *LoginStorageConf `json:"storage,omitempty"`
+}
+
+// LoginNotifierConf 登录通知配置, type 为 webhook 时使用 url、headers, 为 email 时使用 smtp_* 与 from、to
+type LoginNotifierConf struct {
+	Type         string            `json:"type"`
+	Url          string            `json:"url,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty" secret:"true"`
+	SmtpAddr     string            `json:"smtp_addr,omitempty"`
+	SmtpUsername string            `json:"smtp_username,omitempty"`
+	SmtpPassword string            `json:"smtp_password,omitempty" secret:"true"`
+	From         string            `json:"from,omitempty"`
+	To           []string          `json:"to,omitempty"`
+	// Subject 扫码登录邮件的标题
+	Subject string `json:"subject,omitempty"`
+}
+
+// Validate 校验登录配置
+func (c *LoginConf) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := c.Storage.Validate(); err != nil {
+		return err
+	}
+	for idx, notifierConf := range c.Notifiers {
+		if _, err := NewLoginNotifier(notifierConf); err != nil {
+			return errors.WithMessagef(err, "login.notifiers[%d]", idx)
+		}
+	}
+	return nil
+}
+
+// 登录通知事件
+const (
+	// LoginEventQrcode 需要扫码登录
+	LoginEventQrcode = "login_qrcode"
+	// LoginEventLogout 微信掉线或退出
+	LoginEventLogout = "logout"
+)
+
+// LoginEvent 登录通知内容, 扫码登录时包含二维码, 掉线时包含原因
+type LoginEvent struct {
+	Event     string    `json:"event"`
+	Bot       string    `json:"bot"`
+	Uuid      string    `json:"uuid,omitempty"`
+	QrcodeUrl string    `json:"qrcode_url,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Png       []byte    `json:"qrcode_png,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// LoginNotifier 登录通知
+type LoginNotifier interface {
+	NotifyLogin(event *LoginEvent) error
+}
+
+// LoginNotifierFactory 根据配置创建登录通知, 配置无效时返回错误
+type LoginNotifierFactory func(conf *LoginNotifierConf) (LoginNotifier, error)
+
+var loginNotifierFactories = map[string]LoginNotifierFactory{
+	LoginNotifierWebhook: newWebhookLoginNotifier,
+	LoginNotifierEmail:   newEmailLoginNotifier,
+}
+
+// RegisterLoginNotifier 注册自定义的登录通知类型, 需在加载配置前(如 init 中)调用
+func RegisterLoginNotifier(notifierType string, factory LoginNotifierFactory) {
+	loginNotifierFactories[notifierType] = factory
+}
+
+// NewLoginNotifier 根据配置创建登录通知
+func NewLoginNotifier(conf *LoginNotifierConf) (LoginNotifier, error) {
+	factory, ok := loginNotifierFactories[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("不支持的登录通知类型: %s", conf.Type)
+	}
+	return factory(conf)
+}
+
+// showLoginQrcode 展示登录二维码: 在终端中渲染、保存为PNG图片并发送登录通知
+func (b *BotInstance) showLoginQrcode(uuid string) {
+	conf := b.Conf().Login
+	if conf == nil {
+		conf = &LoginConf{}
+	}
+	content := LoginQrcodeContent(uuid)
+	if !conf.DisableTerminalQrcode {
+		text, err := RenderQrcodeTerminal(content)
+		if err != nil {
+			b.logger.Warn(err.Error())
+		} else {
+			// 日志可能只输出到文件, 二维码直接打印到终端
+			fmt.Fprintf(os.Stdout, "[%s]\n%s", b.Name, text)
+		}
+	}
+
+	png, err := EncodeQrcodePng(content)
+	if err != nil {
+		b.logger.Warn(err.Error())
+	}
+	if conf.QrcodeFile != "" && png != nil {
+		if err := SaveQrcodePng(png, conf.QrcodeFile); err != nil {
+			b.logger.Warn("保存登录二维码失败: " + err.Error())
+		} else {
+			b.logger.Info("登录二维码已保存到: " + conf.QrcodeFile)
+		}
+	}
+
+	b.notifyLoginEvent(&LoginEvent{
+		Event:     LoginEventQrcode,
+		Uuid:      uuid,
+		QrcodeUrl: openwechat.GetQrcodeUrl(uuid),
+		Content:   content,
+		Png:       png,
+		Time:      time.Now(),
+	})
+}
+
+// notifyLoginEvent 发送登录通知, 通知较慢, 不阻塞登录流程
+func (b *BotInstance) notifyLoginEvent(event *LoginEvent) {
+	conf := b.Conf().Login
+	if conf == nil {
+		return
+	}
+	event.Bot = b.Name
+	for _, notifierConf := range conf.Notifiers {
+		go b.notifyLogin(notifierConf, event)
+	}
+}
+
+func (b *BotInstance) notifyLogin(conf *LoginNotifierConf, event *LoginEvent) {
+	notifier, err := NewLoginNotifier(conf)
+	if err == nil {
+		err = notifier.NotifyLogin(event)
+	}
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("发送%s登录通知失败: %s", conf.Type, err.Error()))
+		return
+	}
+	b.logger.Info(fmt.Sprintf("已发送%s登录通知", conf.Type))
+}
+
+// webhookLoginNotifier 以JSON格式POST登录二维码到指定地址, 二维码图片为base64编码
+type webhookLoginNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhookLoginNotifier(conf *LoginNotifierConf) (LoginNotifier, error) {
+	if conf.Url == "" {
+		return nil, errors.New("webhook 登录通知需要配置 url")
+	}
+	if err := validateHttpURL("url", conf.Url); err != nil {
+		return nil, err
+	}
+	return &webhookLoginNotifier{
+		url:     conf.Url,
+		headers: conf.Headers,
+		client:  &http.Client{Timeout: loginNotifyTimeout},
+	}, nil
+}
+
+func (n *webhookLoginNotifier) NotifyLogin(event *LoginEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	for key, value := range n.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// emailLoginNotifier 通过SMTP发送带二维码附件的邮件
+type emailLoginNotifier struct {
+	addr    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject string
+}
+
+func newEmailLoginNotifier(conf *LoginNotifierConf) (LoginNotifier, error) {
+	if conf.SmtpAddr == "" || conf.From == "" || len(conf.To) == 0 {
+		return nil, errors.New("email 登录通知需要配置 smtp_addr、from、to")
+	}
+	host, _, err := net.SplitHostPort(conf.SmtpAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "smtp_addr 格式应为 host:port")
+	}
+	notifier := &emailLoginNotifier{
+		addr:    conf.SmtpAddr,
+		from:    conf.From,
+		to:      conf.To,
+		subject: conf.Subject,
+	}
+	if notifier.subject == "" {
+		notifier.subject = "chatgpt-bot 需要扫码登录"
+	}
+	if conf.SmtpUsername != "" {
+		notifier.auth = smtp.PlainAuth("", conf.SmtpUsername, conf.SmtpPassword, host)
+	}
+	return notifier, nil
+}
+
+func (n *emailLoginNotifier) NotifyLogin(event *LoginEvent) error {
+	msg, err := n.buildMessage(event)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(n.addr, n.auth, n.from, n.to, msg)
+}
+
+// buildMessage 构建 multipart 邮件, 扫码登录时正文为二维码地址, 附件为二维码图片
+func (n *emailLoginNotifier) buildMessage(event *LoginEvent) ([]byte, error) {
+	subject := n.subject
+	if event.Event == LoginEventLogout {
+		subject = "chatgpt-bot 微信已掉线"
+	}
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	text, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	if event.Event == LoginEventLogout {
+		fmt.Fprintf(text, "微信已掉线, 正在尝试重新登录\r\n原因: %s\r\n\r\n机器人: %s\r\n时间: %s\r\n",
+			event.Reason, event.Bot, event.Time.Format(TimeFormat))
+	} else {
+		fmt.Fprintf(text, "请使用微信扫描附件中的二维码登录, 或打开下面的地址:\r\n%s\r\n\r\n机器人: %s\r\n时间: %s\r\n",
+			event.QrcodeUrl, event.Bot, event.Time.Format(TimeFormat))
+	}
+
+	if event.Png != nil {
+		attachment, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/png"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="login_qrcode.png"`},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(event.Png)
+		for len(encoded) > 76 {
+			fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(attachment, "%s\r\n", encoded)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
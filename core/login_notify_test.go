@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookLoginNotifier(t *testing.T) {
+	var received LoginEvent
+	var header http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier, err := NewLoginNotifier(&LoginNotifierConf{
+		Type: LoginNotifierWebhook, Url: server.URL, Headers: map[string]string{"Authorization": "Bearer t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &LoginEvent{Event: LoginEventQrcode, Bot: "default", Uuid: "u1",
+		QrcodeUrl: "https://login.weixin.qq.com/qrcode/u1", Png: []byte{1, 2, 3}, Time: time.Now()}
+	if err := notifier.NotifyLogin(event); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Bearer t" || !strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		t.Fatalf("header = %v", header)
+	}
+	if received.Event != LoginEventQrcode || received.Uuid != "u1" || !bytes.Equal(received.Png, event.Png) {
+		t.Fatalf("received = %+v", received)
+	}
+
+	status = http.StatusInternalServerError
+	if err := notifier.NotifyLogin(event); err == nil {
+		t.Fatal("非2xx状态码应返回错误")
+	}
+}
+
+// serveFakeSmtp 接受一个SMTP会话, 不支持STARTTLS与AUTH, 返回收到的邮件内容
+func serveFakeSmtp(t *testing.T, listener net.Listener) <-chan string {
+	data := make(chan string, 1)
+	go func() {
+		defer close(data)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 end with .")
+				var body strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					body.WriteString(strings.TrimPrefix(line, "."))
+				}
+				data <- body.String()
+				reply("250 ok")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return data
+}
+
+func TestEmailLoginNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	data := serveFakeSmtp(t, listener)
+
+	notifier, err := NewLoginNotifier(&LoginNotifierConf{
+		Type: LoginNotifierEmail, SmtpAddr: listener.Addr().String(), From: "bot@example.com", To: []string{"a@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 40)
+	event := &LoginEvent{Event: LoginEventQrcode, Bot: "default",
+		QrcodeUrl: "https://login.weixin.qq.com/qrcode/u1", Png: png, Time: time.Now()}
+	if err := notifier.NotifyLogin(event); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "chatgpt-bot 需要扫码登录" {
+		t.Fatalf("subject = %q, %v", subject, err)
+	}
+	if msg.Header.Get("To") != "a@example.com" {
+		t.Fatalf("to = %q", msg.Header.Get("To"))
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(text)
+	if !strings.Contains(string(body), event.QrcodeUrl) {
+		t.Fatalf("正文应包含二维码地址: %s", body)
+	}
+	attachment, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "login_qrcode.png" {
+		t.Fatalf("filename = %q", attachment.FileName())
+	}
+	encoded, _ := io.ReadAll(attachment)
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, png) {
+		t.Fatalf("附件内容不一致: %v", err)
+	}
+}
+
+func TestEmailLoginNotifierLogoutMessage(t *testing.T) {
+	notifier := &emailLoginNotifier{from: "bot@example.com", to: []string{"a@example.com", "b@example.com"}, subject: "扫码"}
+	raw, err := notifier.buildMessage(&LoginEvent{Event: LoginEventLogout, Bot: "default", Reason: "cookie 过期", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "chatgpt-bot 微信已掉线" {
+		t.Fatalf("subject = %q", subject)
+	}
+	if msg.Header.Get("To") != "a@example.com, b@example.com" {
+		t.Fatalf("to = %q", msg.Header.Get("To"))
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "原因: cookie 过期") || strings.Contains(string(body), "login_qrcode.png") {
+		t.Fatalf("掉线邮件应包含原因且没有二维码附件: %s", body)
+	}
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 登录信息存储类型
+const (
+	LoginStorageFile = "file"
+	LoginStorageKV   = "kv"
+)
+
+const (
+	// defaultLoginStorageFile 单账号模式下默认的登录信息文件
+	defaultLoginStorageFile = ".login.storage.json"
+	// defaultLoginStorageKey 使用kv存储时默认的键
+	defaultLoginStorageKey = "chatgpt-bot/login-storage"
+	// LoginKVStoreDir 内置的目录kv存储, 每个键保存为目录下的一个文件
+	LoginKVStoreDir = "dir"
+)
+
+// LoginStorageConf 登录信息存储配置, 不同部署或容器可使用不同的路径或键隔离会话
+type LoginStorageConf struct {
+	// Type 存储类型: file(默认) 或 kv
+	Type string `json:"type,omitempty"`
+	// Path type 为 file 时是登录信息文件路径, 默认 .login.storage.json; kv_store 为 dir 时是存储目录
+	Path string `json:"path,omitempty"`
+	// Encrypt 使用配置的加密密钥(secret_key_env 或 secret_key_file)加密保存登录信息
+	Encrypt bool `json:"encrypt,omitempty"`
+	// KvStore type 为 kv 时使用的存储, 内置 dir, 其他存储通过 RegisterLoginKVStore 注册
+	KvStore string `json:"kv_store,omitempty"`
+	// KvKey type 为 kv 时使用的键, 默认 chatgpt-bot/login-storage
+	KvKey string `json:"kv_key,omitempty"`
+}
+
+// Validate 校验登录信息存储配置
+func (c *LoginStorageConf) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Type {
+	case "", LoginStorageFile:
+		return nil
+	case LoginStorageKV:
+		if _, ok := loginKVStoreFactories[c.KvStore]; !ok {
+			return fmt.Errorf("login.storage: 未注册的kv存储: %s", c.KvStore)
+		}
+		if c.KvStore == LoginKVStoreDir && c.Path == "" {
+			return errors.New("login.storage: kv_store 为 dir 时需要配置 path")
+		}
+		return nil
+	default:
+		return fmt.Errorf("login.storage: 不支持的存储类型: %s", c.Type)
+	}
+}
+
+// LoginKVStore 保存登录信息的键值存储, Get 在键不存在时返回 nil, nil
+type LoginKVStore interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+}
+
+// LoginKVStoreFactory 根据配置创建键值存储
+type LoginKVStoreFactory func(conf *LoginStorageConf) (LoginKVStore, error)
+
+var loginKVStoreFactories = map[string]LoginKVStoreFactory{
+	LoginKVStoreDir: newDirLoginKVStore,
+}
+
+// RegisterLoginKVStore 注册自定义的键值存储, 需在加载配置前(如 init 中)调用
+func RegisterLoginKVStore(name string, factory LoginKVStoreFactory) {
+	loginKVStoreFactories[name] = factory
+}
+
+// loginStorage 登录信息的读写, 每次保存完整的登录信息
+type loginStorage interface {
+	load() ([]byte, error)
+	save(data []byte) error
+}
+
+// NewLoginStorage 根据配置创建 openwechat 使用的热登录存储, 未配置路径时使用 defaultFile
+func NewLoginStorage(conf *ChatGptConf, defaultFile string) (openwechat.HotReloadStorage, error) {
+	storageConf := &LoginStorageConf{}
+	if conf.Login != nil && conf.Login.Storage != nil {
+		storageConf = conf.Login.Storage
+	}
+
+	var storage loginStorage
+	switch storageConf.Type {
+	case "", LoginStorageFile:
+		path := storageConf.Path
+		if path == "" {
+			path = defaultFile
+		}
+		storage = fileLoginStorage{path: path}
+	case LoginStorageKV:
+		factory, ok := loginKVStoreFactories[storageConf.KvStore]
+		if !ok {
+			return nil, fmt.Errorf("未注册的kv存储: %s", storageConf.KvStore)
+		}
+		store, err := factory(storageConf)
+		if err != nil {
+			return nil, err
+		}
+		key := storageConf.KvKey
+		if key == "" {
+			key = defaultLoginStorageKey
+		}
+		storage = kvLoginStorage{store: store, key: key}
+	default:
+		return nil, fmt.Errorf("不支持的登录信息存储类型: %s", storageConf.Type)
+	}
+
+	if storageConf.Encrypt {
+		key, err := loadSecretKey(conf.SecretKeyEnv, conf.SecretKeyFile)
+		if err != nil {
+			return nil, errors.WithMessage(err, "加密登录信息")
+		}
+		storage = encryptedLoginStorage{storage: storage, key: key}
+	}
+	return &hotReloadStorage{storage: storage}, nil
+}
+
+// hotReloadStorage 把 loginStorage 适配为 openwechat.HotReloadStorage
+// openwechat 每次写入完整的登录信息, 读取时一次性加载后按需返回
+type hotReloadStorage struct {
+	storage loginStorage
+	reader  *bytes.Reader
+	mu      sync.Mutex
+}
+
+func (s *hotReloadStorage) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader == nil {
+		data, err := s.storage.load()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, openwechat.ErrInvalidStorage
+		}
+		s.reader = bytes.NewReader(data)
+	}
+	return s.reader.Read(p)
+}
+
+func (s *hotReloadStorage) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.storage.save(p); err != nil {
+		return 0, err
+	}
+	s.reader = nil
+	return len(p), nil
+}
+
+// fileLoginStorage 文件存储, 原子写入且只有当前用户可读写
+type fileLoginStorage struct {
+	path string
+}
+
+func (s fileLoginStorage) load() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s fileLoginStorage) save(data []byte) error {
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return err
+	}
+	// 旧版本创建的登录信息文件权限较宽, 保存时收紧
+	return os.Chmod(s.path, 0600)
+}
+
+// kvLoginStorage 键值存储
+type kvLoginStorage struct {
+	store LoginKVStore
+	key   string
+}
+
+func (s kvLoginStorage) load() ([]byte, error) {
+	return s.store.Get(s.key)
+}
+
+func (s kvLoginStorage) save(data []byte) error {
+	return s.store.Set(s.key, data)
+}
+
+// encryptedLoginStorage 使用AES-GCM加密保存登录信息, 读取到未加密的旧数据时直接使用, 下次保存时加密
+type encryptedLoginStorage struct {
+	storage loginStorage
+	key     []byte
+}
+
+func (s encryptedLoginStorage) load() ([]byte, error) {
+	data, err := s.storage.load()
+	if err != nil || !strings.HasPrefix(string(data), EncryptedPrefix) {
+		return data, err
+	}
+	plaintext, err := DecryptSecret(s.key, string(data))
+	if err != nil {
+		return nil, errors.WithMessage(err, "解密登录信息失败")
+	}
+	return []byte(plaintext), nil
+}
+
+func (s encryptedLoginStorage) save(data []byte) error {
+	ciphertext, err := EncryptSecret(s.key, string(data))
+	if err != nil {
+		return err
+	}
+	return s.storage.save([]byte(ciphertext))
+}
+
+// dirLoginKVStore 目录键值存储, 键中的路径分隔符替换为下划线后作为文件名
+type dirLoginKVStore struct {
+	dir string
+}
+
+func newDirLoginKVStore(conf *LoginStorageConf) (LoginKVStore, error) {
+	if err := os.MkdirAll(conf.Path, 0700); err != nil {
+		return nil, errors.Wrap(err, "创建登录信息目录失败")
+	}
+	return dirLoginKVStore{dir: conf.Path}, nil
+}
+
+func (s dirLoginKVStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s dirLoginKVStore) Set(key string, value []byte) error {
+	return writeFileAtomic(s.path(key), value, 0600)
+}
+
+func (s dirLoginKVStore) path(key string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(key)
+	return filepath.Join(s.dir, name)
+}
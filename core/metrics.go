@@ -0,0 +1,77 @@
+package core
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// metricsRegistry 机器人指标注册表, 通过 /metrics 暴露
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	metricMessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_bot_messages_received_total",
+		Help: "收到的消息数, 按账号、消息类型与群聊统计, 私聊的group为空, 不在群聊白名单中的群聊统一为other",
+	}, []string{"bot", "type", "group"})
+
+	metricMessagesFiltered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_bot_messages_filtered_total",
+		Help: "被群聊过滤规则过滤的消息数, 按账号统计, reason为prefix或group_name",
+	}, []string{"bot", "reason"})
+
+	metricLLMRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatgpt_bot_llm_request_duration_seconds",
+		Help:    "模型请求耗时",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"backend", "model", "result"})
+
+	metricLLMTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_bot_llm_tokens_total",
+		Help: "模型消耗的token数, type为prompt或completion",
+	}, []string{"backend", "model", "type"})
+
+	metricErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_bot_errors_total",
+		Help: "错误数, 按账号与类别统计",
+	}, []string{"bot", "category"})
+
+	metricQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chatgpt_bot_queue_depth",
+		Help: "正在处理中的消息数, 按账号统计",
+	}, []string{"bot"})
+)
+
+// 错误类别
+const (
+	errorCategoryFilter  = "filter"
+	errorCategoryReply   = "reply"
+	errorCategoryLLM     = "llm"
+	errorCategoryConfig  = "config"
+	errorCategoryLogin   = "login"
+	errorCategoryWebhook = "webhook"
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricMessagesReceived,
+		metricMessagesFiltered,
+		metricLLMRequestDuration,
+		metricLLMTokens,
+		metricErrors,
+		metricQueueDepth,
+	)
+}
+
+// metricGroupOther 不在群聊白名单中的群聊统一使用的标签值
+const metricGroupOther = "other"
+
+// metricGroupLabel 获取群聊的指标标签, 只有白名单中列出的群聊单独统计, 避免群聊名称导致标签数量无限增长
+// 白名单为空时所有群聊都会回复, 但统一统计为 other
+func metricGroupLabel(conf *ChatGptConf, groupName string) string {
+	if groupName == "" || conf.groupNameWhiteListMapping[groupName] {
+		return groupName
+	}
+	return metricGroupOther
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// notifyOwner 给机器人的主人发送通知, 未配置 owner 时发送到文件传输助手
+func (b *BotInstance) notifyOwner(text string) {
+	if err := b.sendToOwner(text); err != nil {
+		b.logger.Warn("发送通知失败: " + err.Error())
+	}
+}
+
+func (b *BotInstance) sendToOwner(text string) error {
+	bot := b.bot.Load()
+	if bot == nil {
+		return errors.New("机器人未登录")
+	}
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		return err
+	}
+	owner := b.Conf().Owner
+	if owner == "" {
+		_, err = self.FileHelper().SendText(text)
+		return err
+	}
+	friends, err := self.Friends()
+	if err != nil {
+		return errors.Wrap(err, "获取好友列表失败")
+	}
+	found := friends.SearchByRemarkName(1, owner)
+	if found.Count() == 0 {
+		found = friends.SearchByNickName(1, owner)
+	}
+	if found.Count() == 0 {
+		return fmt.Errorf("未找到主人: %s", owner)
+	}
+	_, err = found.First().SendText(text)
+	return err
+}
+
+// onBreakerStateChange 熔断器状态变化时记录日志并通知主人
+func (b *BotInstance) onBreakerStateChange(name string, from, to BreakerState) {
+	text := fmt.Sprintf("[熔断器] %s: %s -> %s", name, from, to)
+	logger := b.componentLogger(LogComponentLLM)
+	if to == BreakerOpen {
+		logger.Warn(text)
+	} else {
+		logger.Info(text)
+	}
+	// 发送微信消息较慢, 不阻塞模型请求
+	go b.notifyOwner(text)
+}
@@ -8,21 +8,14 @@ import (
 	"github.com/samber/lo"
 	"github.com/sashabaranov/go-openai"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // MatchGroupName 判断是否群聊名称是否符合
 func (i *ChatGptConf) MatchGroupName(groupName string) bool {
-	i.groupNameWhiteList.Do(func() {
-		i.groupNameWhiteListMapping = make(map[string]bool)
-		for _, name := range i.GroupNameWhiteList {
-			if len(name) == 0 {
-				continue
-			}
-			i.groupNameWhiteListMapping[name] = true
-		}
-	})
 	if len(i.groupNameWhiteListMapping) == 0 {
 		return true
 	}
@@ -50,57 +43,310 @@ func (i *ChatGptConf) GetDefaultPrompt() openai.ChatCompletionMessage {
 	}
 }
 
-// AddGroupNameWhiteList 添加群聊白名单
+// AddGroupNameWhiteList 添加群聊白名单, 仅可在 ConfHelper.Update 的副本上调用
 func (i *ChatGptConf) AddGroupNameWhiteList(name string) {
 	i.GroupNameWhiteList = append(i.GroupNameWhiteList, name)
 	i.GroupNameWhiteList = lo.Uniq(i.GroupNameWhiteList)
-	i.groupNameWhiteList = sync.Once{}
 }
 
-// RemoveGroupNameWhiteList 移除群聊白名单
+// RemoveGroupNameWhiteList 移除群聊白名单, 仅可在 ConfHelper.Update 的副本上调用
 func (i *ChatGptConf) RemoveGroupNameWhiteList(name string) {
 	idx := lo.IndexOf(i.GroupNameWhiteList, name)
 	if idx != -1 {
 		i.GroupNameWhiteList = append(i.GroupNameWhiteList[:idx], i.GroupNameWhiteList[idx+1:]...)
-		i.groupNameWhiteList = sync.Once{}
 	}
 }
 
-// SetDefaultPrompt 设置默认提示
+// SetDefaultPrompt 设置默认提示, 仅可在 ConfHelper.Update 的副本上调用
 func (i *ChatGptConf) SetDefaultPrompt(value string) {
 	i.CharacterDesc = value
 }
 
+// DefaultBackend 顶层的 provider、token、tokens 组成默认后端
+func (i *ChatGptConf) DefaultBackend() *BackendConf {
+	return &BackendConf{
+		Provider:       i.Provider,
+		Token:          i.Token,
+		Tokens:         i.Tokens,
+		KeySelection:   i.KeySelection,
+		RequestTimeout: i.RequestTimeout,
+	}
+}
+
+// GetModelProfile 获取群聊使用的模型配置, 未单独配置的群聊与私聊使用顶层的 model 与 fallbacks
+func (i *ChatGptConf) GetModelProfile(groupName string) *ModelProfile {
+	profile := &ModelProfile{Model: i.Model, Fallbacks: i.Fallbacks}
+	if groupProfile := i.ModelProfiles[groupName]; groupProfile != nil && groupName != "" {
+		profile = groupProfile
+	}
+	if profile.Model == "" {
+		profile = &ModelProfile{Model: openai.GPT3Dot5Turbo, Fallbacks: profile.Fallbacks}
+	}
+	return profile
+}
+
+// AdminNames 可以在聊天中执行管理命令的好友备注或昵称, 包括 owner 与 admins
+func (i *ChatGptConf) AdminNames() []string {
+	names := make([]string, 0, len(i.Admins)+1)
+	for _, name := range append([]string{i.Owner}, i.Admins...) {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsAdmin 好友备注或昵称是否为管理员
+func (i *ChatGptConf) IsAdmin(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, admin := range i.AdminNames() {
+		if admin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaintenanceMessage 获取模型服务熔断时回复的维护提示
+func (i *ChatGptConf) GetMaintenanceMessage() string {
+	if i.MaintenanceMessage == "" {
+		return defaultMaintenanceMessage
+	}
+	return i.MaintenanceMessage
+}
+
+// Validate 校验配置, 在配置发布前调用
+func (i *ChatGptConf) Validate() error {
+	if err := i.DefaultBackend().Validate(DefaultBackendName); err != nil {
+		return err
+	}
+	for name, backend := range i.Backends {
+		if name == DefaultBackendName {
+			return fmt.Errorf("backends 不能使用保留名称: %s", DefaultBackendName)
+		}
+		if backend == nil {
+			return fmt.Errorf("backends[%s] 不能为空", name)
+		}
+		if err := backend.Validate("backend " + name); err != nil {
+			return err
+		}
+	}
+	if err := i.Login.Validate(); err != nil {
+		return err
+	}
+	if err := i.Webhook.Validate(); err != nil {
+		return err
+	}
+	profiles := map[string]*ModelProfile{"": {Model: i.Model, Fallbacks: i.Fallbacks}}
+	for groupName, profile := range i.ModelProfiles {
+		if profile == nil {
+			return fmt.Errorf("model_profiles[%s] 不能为空", groupName)
+		}
+		profiles[groupName] = profile
+	}
+	for groupName, profile := range profiles {
+		for _, fallback := range profile.Fallbacks {
+			if fallback == nil || fallback.Model == "" {
+				return fmt.Errorf("model_profiles[%s]: 备用模型不能为空", groupName)
+			}
+			// 与 CreateChatCompletion 一致, backend 为空或 default 时使用默认后端
+			if fallback.Backend == "" || fallback.Backend == DefaultBackendName {
+				continue
+			}
+			if _, ok := i.Backends[fallback.Backend]; !ok {
+				return fmt.Errorf("model_profiles[%s]: 备用模型 %s 使用的后端不存在: %s", groupName, fallback.Model, fallback.Backend)
+			}
+		}
+	}
+	return nil
+}
+
+// Clone 深拷贝配置, 用于写时复制
+// 借助序列化完成深拷贝, 新增的嵌套字段无需在此单独处理
+func (i *ChatGptConf) Clone() (*ChatGptConf, error) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, errors.Wrap(err, "复制配置失败")
+	}
+	conf := &ChatGptConf{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, errors.Wrap(err, "复制配置失败")
+	}
+	return conf, nil
+}
+
+// buildIndex 构建群聊白名单索引, 在配置发布前调用, 发布后只读
+func (i *ChatGptConf) buildIndex() {
+	i.groupNameWhiteListMapping = make(map[string]bool)
+	for _, name := range i.GroupNameWhiteList {
+		if len(name) == 0 {
+			continue
+		}
+		i.groupNameWhiteListMapping[name] = true
+	}
+}
+
+// ConfHelper 配置管理
+// 配置以不可变快照的形式发布, 读取方通过 GetConf 获取当前快照, 修改通过 Update 复制后原子替换
+//
+// 配置优先级从低到高: 默认值 < 配置文件 < 环境变量(CHATGPT_BOT_*)
+// GetConf 返回合并后的生效配置, 保存时只写入配置文件中的值, 环境变量不会落盘
 type ConfHelper struct {
-	conf *ChatGptConf
-	file string
+	conf atomic.Pointer[ChatGptConf]
+	// raw 配置文件中的原始值, 仅在持有 mu 时访问
+	raw    *ChatGptConf
+	file   string
+	format string
+	// envPrefix 覆盖配置的环境变量前缀, 单账号为 CHATGPT_BOT_, 多账号为 CHATGPT_BOT_<账号名称>_
+	envPrefix string
+	// hooks 配置发布钩子, 仅在持有 mu 时访问
+	hooks []PublishHook
+	// mu 串行化配置的加载、修改与保存
+	mu sync.Mutex
 }
 
 func NewTestConfHelper() *ConfHelper {
-	return &ConfHelper{
-		conf: &ChatGptConf{
-			Token:                     "",
-			GroupChatPrefix:           nil,
-			GroupNameWhiteList:        nil,
-			ConversationMaxTokens:     100,
-			CharacterDesc:             "test",
-			ConversationTimeout:       0,
-			groupNameWhiteList:        sync.Once{},
-			groupNameWhiteListMapping: nil,
-		},
-		file: "test.json",
-	}
+	helper := &ConfHelper{file: "test.json", format: ConfFormatJson, envPrefix: EnvPrefix}
+	helper.store(&ChatGptConf{
+		Token:                 "",
+		GroupChatPrefix:       nil,
+		GroupNameWhiteList:    nil,
+		ConversationMaxTokens: 100,
+		CharacterDesc:         "test",
+		ConversationTimeout:   0,
+	})
+	return helper
 }
 
 func NewConfHelper(file string) *ConfHelper {
-	return &ConfHelper{file: file}
+	return NewBotConfHelper(file, EnvPrefix)
+}
+
+// NewBotConfHelper 创建使用指定环境变量前缀覆盖配置的配置管理, 用于多账号模式下区分各账号的环境变量
+func NewBotConfHelper(file, envPrefix string) *ConfHelper {
+	return &ConfHelper{file: file, format: DetectConfFormat(file), envPrefix: envPrefix}
 }
 
+// GetConf 获取当前配置快照, 返回值不可修改
 func (i *ConfHelper) GetConf() *ChatGptConf {
-	return i.conf
+	return i.conf.Load()
+}
+
+// store 发布新的配置快照
+func (i *ConfHelper) store(conf *ChatGptConf) {
+	conf.buildIndex()
+	i.conf.Store(conf)
+}
+
+// PublishHook 配置发布前调用, 返回错误时放弃发布, 返回的 commit 不为 nil 时在新配置发布后调用
+type PublishHook func(conf *ChatGptConf) (commit func(), err error)
+
+// OnPublish 注册配置发布钩子, 需在配置开始修改前调用
+func (i *ConfHelper) OnPublish(hook PublishHook) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.hooks = append(i.hooks, hook)
+}
+
+// publish 记录配置文件的原始值, 合并环境变量并解析敏感字段后发布, 调用方需持有 mu
+func (i *ConfHelper) publish(raw *ChatGptConf) (*ChatGptConf, error) {
+	conf, commits, err := i.prepare(raw)
+	if err != nil {
+		return nil, err
+	}
+	i.commit(raw, conf, commits)
+	return conf, nil
+}
+
+// prepare 生成生效配置并依次调用发布钩子, 调用方需持有 mu
+func (i *ConfHelper) prepare(raw *ChatGptConf) (*ChatGptConf, []func(), error) {
+	conf, err := effectiveConf(raw, i.envPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	commits := make([]func(), 0, len(i.hooks))
+	for _, hook := range i.hooks {
+		commit, err := hook(conf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if commit != nil {
+			commits = append(commits, commit)
+		}
+	}
+	return conf, commits, nil
+}
+
+// commit 发布已准备好的配置, 调用方需持有 mu
+func (i *ConfHelper) commit(raw, conf *ChatGptConf, commits []func()) {
+	i.raw = raw
+	i.store(conf)
+	for _, commit := range commits {
+		commit()
+	}
+}
+
+// effectiveConf 复制配置文件中的值, 合并 envPrefix 开头的环境变量并解析敏感字段后校验
+func effectiveConf(raw *ChatGptConf, envPrefix string) (*ChatGptConf, error) {
+	conf, err := raw.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(conf, envPrefix); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Update 复制配置文件中的值并修改, 修改完成后重新合并环境变量并原子替换, 返回新的配置快照
+func (i *ConfHelper) Update(modify func(conf *ChatGptConf)) (*ChatGptConf, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	raw := i.raw
+	if raw == nil {
+		raw = i.GetConf()
+	}
+	raw, err := raw.Clone()
+	if err != nil {
+		return nil, err
+	}
+	modify(raw)
+	return i.publish(raw)
+}
+
+// UpdateAndSave 修改配置文件中的值, 先写入配置文件, 成功后再发布新的配置快照并记录历史版本
+// 整个过程持有 mu, 写入失败时内存中的配置保持不变, 并发的修改不会交错
+func (i *ConfHelper) UpdateAndSave(modify func(conf *ChatGptConf), operator, action string) (*ChatGptConf, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	raw := i.raw
+	if raw == nil {
+		raw = i.GetConf()
+	}
+	raw, err := raw.Clone()
+	if err != nil {
+		return nil, err
+	}
+	modify(raw)
+	conf, commits, err := i.prepare(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.saveConf(raw, conf, commits, operator, action); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
 
-func (i *ConfHelper) MatchGroupFilter(msg *openwechat.Message) (bool, string, error) {
+func (i *ConfHelper) MatchGroupFilter(bot string, msg *openwechat.Message) (bool, string, error) {
 	if !msg.IsComeFromGroup() {
 		return true, "不是来自群组的信息", nil
 	}
@@ -108,68 +354,286 @@ func (i *ConfHelper) MatchGroupFilter(msg *openwechat.Message) (bool, string, er
 	if err != nil {
 		return false, "失败", errors.Wrap(err, "获取群消息群组失败")
 	}
-	matchPrefix := i.conf.MatchGroupChatMentionPrefix(msg.Content)
-	matchGroupName := i.conf.MatchGroupName(senderFrom.NickName)
+	match, errMsg := i.MatchGroupMessage(bot, senderFrom.NickName, msg.Content)
+	return match, errMsg, nil
+}
+
+// MatchGroupMessage 群聊消息是否匹配群聊前缀与群聊白名单, 不匹配时返回原因, 过滤的消息计入账号 bot 的指标
+func (i *ConfHelper) MatchGroupMessage(bot, groupName, content string) (bool, string) {
+	conf := i.GetConf()
+	matchPrefix := conf.MatchGroupChatMentionPrefix(content)
+	matchGroupName := conf.MatchGroupName(groupName)
 
 	errMsg := ""
 	if !matchPrefix {
-		errMsg += fmt.Sprintf("群聊前缀不符合;期望前缀:%v;当前信息:%s\n", i.conf.GroupChatPrefix, msg.Content)
+		metricMessagesFiltered.WithLabelValues(bot, "prefix").Inc()
+		errMsg += fmt.Sprintf("群聊前缀不符合;期望前缀:%v;当前信息:%s\n", conf.GroupChatPrefix, RedactBody(content))
 	}
 	if !matchGroupName {
-		errMsg += fmt.Sprintf("群聊名称不符合;期望名称:%v;当前群聊名称:%s", i.conf.GroupNameWhiteList, senderFrom.NickName)
+		metricMessagesFiltered.WithLabelValues(bot, "group_name").Inc()
+		errMsg += fmt.Sprintf("群聊名称不符合;期望名称:%v;当前群聊名称:%s", conf.GroupNameWhiteList, groupName)
 	}
-	return matchPrefix && matchGroupName, errMsg, nil
+	return matchPrefix && matchGroupName, errMsg
 }
 
 // ConversationMaxTokens 获取对话最大长度
 func (i *ConfHelper) ConversationMaxTokens() int {
-	if i.conf.ConversationMaxTokens == 0 {
+	conf := i.GetConf()
+	if conf.ConversationMaxTokens == 0 {
 		return 1000
 	}
-	return i.conf.ConversationMaxTokens
+	return conf.ConversationMaxTokens
 }
 
 // ConversationTimeout 获取对话超时时间
 func (i *ConfHelper) ConversationTimeout() int {
-	if i.conf.ConversationTimeout == 0 {
+	conf := i.GetConf()
+	if conf.ConversationTimeout == 0 {
 		return 3600
 	}
-	return i.conf.ConversationTimeout
+	return conf.ConversationTimeout
 }
 
-// LoadJsonConf 从文件中加载配置
-func (i *ConfHelper) LoadJsonConf() (conf *ChatGptConf, err error) {
-	conf = &ChatGptConf{}
+// LoadConf 从文件中加载配置, 格式由文件扩展名决定
+func (i *ConfHelper) LoadConf() (conf *ChatGptConf, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
-	// read content from file and unmarshal into json
 	data, err := os.ReadFile(i.file)
 	if err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(data, conf); err != nil {
+	raw, err := i.parseConf(data)
+	if err != nil {
+		return nil, err
+	}
+	return i.publish(raw)
+}
+
+// SaveConf 保存配置文件中的值到文件, 并记录操作人与操作内容到配置历史
+func (i *ConfHelper) SaveConf(operator, action string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	raw := i.raw
+	if raw == nil {
+		raw = i.GetConf()
+	}
+	data, err := marshalConf(i.format, raw)
+	if err != nil {
+		return err
+	}
+	history := i.History()
+	if err := i.writeConf(history, data); err != nil {
+		return err
+	}
+	i.appendHistory(history, operator, action, data)
+	return nil
+}
+
+// EffectiveConf 获取合并后的生效配置, 敏感字段已遮盖
+func (i *ConfHelper) EffectiveConf() ([]byte, error) {
+	return i.maskedConf(i.GetConf())
+}
+
+// FileConf 获取配置文件中的值, 敏感字段已遮盖
+func (i *ConfHelper) FileConf() ([]byte, error) {
+	i.mu.Lock()
+	raw := i.raw
+	i.mu.Unlock()
+	return i.maskedConf(raw)
+}
+
+func (i *ConfHelper) maskedConf(conf *ChatGptConf) ([]byte, error) {
+	if conf == nil {
+		return nil, errors.New("配置未加载")
+	}
+	// 借助序列化深拷贝, 避免修改已发布的快照
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	masked := &ChatGptConf{}
+	if err := json.Unmarshal(data, masked); err != nil {
+		return nil, err
+	}
+	maskSecrets(reflect.ValueOf(masked))
+	return marshalConf(i.format, masked)
+}
+
+// History 获取配置历史
+func (i *ConfHelper) History() *ConfHistory {
+	conf := i.GetConf()
+	if conf == nil {
+		return NewConfHistory(i.file, "", 0)
+	}
+	return NewConfHistory(i.file, conf.ConfigHistoryDir, conf.ConfigHistoryLimit)
+}
+
+// DiffHistory 比较指定历史版本与当前配置文件的差异, 两侧的敏感字段都会遮盖
+func (i *ConfHelper) DiffHistory(version int) (string, error) {
+	entry, err := i.History().Get(version)
+	if err != nil {
+		return "", err
+	}
+	before, err := i.maskedContent([]byte(entry.Content))
+	if err != nil {
+		return "", errors.WithMessagef(err, "配置历史版本 v%d 解析失败", version)
+	}
+	current, err := os.ReadFile(i.file)
+	if err != nil {
+		return "", err
+	}
+	after, err := i.maskedContent(current)
+	if err != nil {
+		return "", err
+	}
+	return DiffChangedLines(string(before), string(after)), nil
+}
+
+// Rollback 回滚到指定的历史版本, 回滚本身也会作为新版本记录
+// 历史版本中的敏感字段已遮盖, 回滚时按字段路径还原为当前配置文件中的值, 无法还原时返回错误
+func (i *ConfHelper) Rollback(version int, operator string) (*ChatGptConf, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, err := i.History().Get(version)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := i.parseConf([]byte(entry.Content))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "配置历史版本 v%d 解析失败", version)
+	}
+	if err := restoreSecrets(raw, i.raw); err != nil {
+		return nil, errors.WithMessagef(err, "配置历史版本 v%d 的密钥已变更", version)
+	}
+	// 先校验再写入, 避免无效配置落盘
+	conf, commits, err := i.prepare(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.saveConf(raw, conf, commits, operator, fmt.Sprintf("rollback to v%d", version)); err != nil {
 		return nil, err
 	}
-	i.conf = conf
 	return conf, nil
 }
 
-// SaveJsonConf 保存配置到文件
-func (i *ConfHelper) SaveJsonConf(conf *ChatGptConf) error {
-	data, err := json.MarshalIndent(conf, "", "  ")
+// Replace 使用新的配置内容替换配置文件, 仍为遮盖值的敏感字段保留原值
+func (i *ConfHelper) Replace(data []byte, operator, action string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	raw, err := i.parseConf(data)
+	if err != nil {
+		return err
+	}
+	if err := restoreSecrets(raw, i.raw); err != nil {
+		return err
+	}
+	// 先校验再写入, 避免无效配置落盘
+	conf, commits, err := i.prepare(raw)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(i.file, data, 0644)
+	return i.saveConf(raw, conf, commits, operator, action)
+}
+
+// saveConf 写入配置文件, 成功后发布已准备好的配置, 最后记录历史版本, 调用方需持有 mu
+func (i *ConfHelper) saveConf(raw, conf *ChatGptConf, commits []func(), operator, action string) error {
+	content, err := marshalConf(i.format, raw)
+	if err != nil {
+		return err
+	}
+	// 历史目录取自修改前的配置, 与首次保存时记录的原始配置保持在同一目录
+	history := i.History()
+	if err := i.writeConf(history, content); err != nil {
+		return err
+	}
+	i.commit(raw, conf, commits)
+	i.appendHistory(history, operator, action, content)
+	return nil
+}
+
+// writeConf 原子写入配置文件, 历史为空时先记录原始配置, 调用方需持有 mu
+func (i *ConfHelper) writeConf(history *ConfHistory, data []byte) error {
+	if history.IsEmpty() {
+		// 首次保存时记录原始配置, 以便回滚到修改前的状态
+		if origin, err := os.ReadFile(i.file); err == nil {
+			i.appendHistory(history, "system", "initial", origin)
+		}
+	}
+	return writeFileAtomic(i.file, data, 0644)
+}
+
+// appendHistory 遮盖敏感字段后追加历史版本, 历史文件中不保存明文密钥
+func (i *ConfHelper) appendHistory(history *ConfHistory, operator, action string, data []byte) {
+	content, err := i.maskedContent(data)
+	if err == nil {
+		_, err = history.Append(operator, action, content)
+	}
+	if err != nil {
+		ComponentLogger(LogComponentConfig).Warn("记录配置历史失败: " + err.Error())
+	}
+}
+
+// maskedContent 遮盖配置内容中的敏感字段, enc: 加密值本身是密文, 原样保留以便回滚
+func (i *ConfHelper) maskedContent(data []byte) ([]byte, error) {
+	conf, err := i.parseConf(data)
+	if err != nil {
+		return nil, err
+	}
+	_ = walkSecrets(reflect.ValueOf(conf), func(value string) (string, error) {
+		if strings.HasPrefix(value, EncryptedPrefix) {
+			return value, nil
+		}
+		return MaskSecret(value), nil
+	})
+	return marshalConf(i.format, conf)
+}
+
+// parseConf 按配置文件格式解析配置内容
+func (i *ConfHelper) parseConf(data []byte) (*ChatGptConf, error) {
+	conf := &ChatGptConf{}
+	if err := unmarshalConf(i.format, data, conf); err != nil {
+		return nil, errors.Wrapf(err, "解析%s配置失败", i.format)
+	}
+	return conf, nil
 }
 
 type ChatGptConf struct {
-	Token                 string   `json:"token"`
-	GroupChatPrefix       []string `json:"group_chat_prefix"`
-	GroupNameWhiteList    []string `json:"group_name_white_list"`
-	ConversationMaxTokens int      `json:"conversation_max_tokens"`
-	CharacterDesc         string   `json:"character_desc"`
-	ConversationTimeout   int      `json:"conversation_timeout"`
-
-	groupNameWhiteList        sync.Once
+	Token                 string                   `json:"token,omitempty" secret:"true"`
+	TokenFile             string                   `json:"token_file,omitempty"`
+	TokenEnv              string                   `json:"token_env,omitempty"`
+	SecretKeyEnv          string                   `json:"secret_key_env,omitempty"`
+	SecretKeyFile         string                   `json:"secret_key_file,omitempty"`
+	Tokens                []*KeyConf               `json:"tokens,omitempty"`
+	KeySelection          string                   `json:"key_selection,omitempty"`
+	RequestTimeout        int                      `json:"request_timeout,omitempty"`
+	Provider              *ProviderConf            `json:"provider,omitempty"`
+	Backends              map[string]*BackendConf  `json:"backends,omitempty"`
+	Model                 string                   `json:"model,omitempty"`
+	Fallbacks             []*FallbackConf          `json:"fallbacks,omitempty"`
+	ModelProfiles         map[string]*ModelProfile `json:"model_profiles,omitempty"`
+	ShowModel             bool                     `json:"show_model,omitempty"`
+	CircuitBreaker        *BreakerConf             `json:"circuit_breaker,omitempty"`
+	MaintenanceMessage    string                   `json:"maintenance_message,omitempty"`
+	Owner                 string                   `json:"owner,omitempty"`
+	Admins                []string                 `json:"admins,omitempty"`
+	Http                  *HttpConf                `json:"http,omitempty"`
+	Login                 *LoginConf               `json:"login,omitempty"`
+	Webhook               *WebhookConf             `json:"webhook,omitempty"`
+	Send                  *SendConf                `json:"send,omitempty"`
+	EventLog              *EventLogConf            `json:"event_log,omitempty"`
+	GroupChatPrefix       []string                 `json:"group_chat_prefix"`
+	GroupNameWhiteList    []string                 `json:"group_name_white_list"`
+	ConversationMaxTokens int                      `json:"conversation_max_tokens"`
+	CharacterDesc         string                   `json:"character_desc"`
+	ConversationTimeout   int                      `json:"conversation_timeout"`
+	ConfigHistoryDir      string                   `json:"config_history_dir,omitempty"`
+	ConfigHistoryLimit    int                      `json:"config_history_limit,omitempty"`
+	AdminAuditFile        string                   `json:"admin_audit_file,omitempty"`
+
+	// groupNameWhiteListMapping 群聊白名单索引, 配置发布时构建
 	groupNameWhiteListMapping map[string]bool
 }
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConfHelperConcurrentUpdate 并发修改与读取配置, 读取方拿到的快照必须完整一致, 需配合 go test -race 运行
+func TestConfHelperConcurrentUpdate(t *testing.T) {
+	helper := NewTestConfHelper()
+	if _, err := helper.Update(func(conf *ChatGptConf) {
+		conf.CharacterDesc = "0"
+		conf.GroupChatPrefix = []string{"@bot"}
+		conf.GroupNameWhiteList = []string{"group-0", "stable"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, readers, rounds = 4, 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < rounds; n++ {
+				value := strconv.Itoa(w*rounds + n)
+				if _, err := helper.Update(func(conf *ChatGptConf) {
+					conf.CharacterDesc = value
+					conf.GroupNameWhiteList = []string{"group-" + value, "stable"}
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < rounds; n++ {
+				conf := helper.GetConf()
+				group := "group-" + conf.CharacterDesc
+				if len(conf.GroupNameWhiteList) != 2 || conf.GroupNameWhiteList[0] != group {
+					errs <- fmt.Errorf("快照不一致: prompt=%s groups=%v", conf.CharacterDesc, conf.GroupNameWhiteList)
+					return
+				}
+				if !conf.MatchGroupName(group) || conf.MatchGroupName("group-x") {
+					errs <- fmt.Errorf("群聊白名单索引与快照不一致: %s", group)
+					return
+				}
+				// 每个快照中都存在的群聊, 读取时配置被替换也必须匹配
+				if match, reason := helper.MatchGroupMessage("test", "stable", "@bot hello"); !match {
+					errs <- fmt.Errorf("群聊消息未匹配: %s", reason)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConfHelperUpdateDoesNotModifyPublishedSnapshot(t *testing.T) {
+	helper := NewTestConfHelper()
+	before := helper.GetConf()
+	after, err := helper.Update(func(conf *ChatGptConf) {
+		conf.AddGroupNameWhiteList("a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.GroupNameWhiteList) != 0 {
+		t.Fatalf("旧快照被修改: %v", before.GroupNameWhiteList)
+	}
+	if !after.MatchGroupName("a") || helper.GetConf() != after {
+		t.Fatalf("新快照未发布: %v", after.GroupNameWhiteList)
+	}
+}
+
+func TestConfHistoryMasksSecrets(t *testing.T) {
+	const secret = "sk-plaintext-secret-1234"
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"token":"`+secret+`","character_desc":"v1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewConfHelper(file)
+	if _, err := helper.LoadConf(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := helper.Update(func(conf *ChatGptConf) { conf.CharacterDesc = "v2" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.SaveConf("test", "prompt set"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := helper.History().List()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("history = %v, %v", entries, err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Content, secret) {
+			t.Fatalf("v%d 保存了明文密钥: %s", entry.Version, entry.Content)
+		}
+	}
+	diff, err := helper.DiffHistory(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(diff, secret) || !strings.Contains(diff, "v1") {
+		t.Fatalf("diff = %s", diff)
+	}
+
+	conf, err := helper.Rollback(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Token != secret || conf.CharacterDesc != "v1" {
+		t.Fatalf("回滚后 token = %s, prompt = %s", conf.Token, conf.CharacterDesc)
+	}
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), secret) {
+		t.Fatalf("回滚后配置文件未还原密钥: %s", data)
+	}
+}
+
+func TestEffectiveConfRejectsNilTokens(t *testing.T) {
+	helper := NewConfHelper("config.json")
+	raw, err := helper.parseConf([]byte(`{"tokens":[null],"backends":{"azure":{"tokens":[{"token":"sk"},null]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := effectiveConf(raw, EnvPrefix); err == nil || !strings.Contains(err.Error(), "tokens[0]") {
+		t.Fatalf("err = %v, want tokens[0] 不能为空", err)
+	}
+	raw.Tokens = nil
+	if _, err := effectiveConf(raw, EnvPrefix); err == nil || !strings.Contains(err.Error(), "tokens[1]") {
+		t.Fatalf("err = %v, want backend azure: tokens[1] 不能为空", err)
+	}
+}
+
+func TestValidateModelProfiles(t *testing.T) {
+	cases := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{name: "空的模型配置", conf: `{"token":"sk","model_profiles":{"g":null}}`, want: "model_profiles[g] 不能为空"},
+		{name: "空的备用模型", conf: `{"token":"sk","fallbacks":[null]}`, want: "备用模型不能为空"},
+		{name: "空的后端", conf: `{"token":"sk","backends":{"b":null}}`, want: "backends[b] 不能为空"},
+		{name: "后端不存在", conf: `{"token":"sk","fallbacks":[{"model":"gpt-4","backend":"x"}]}`, want: "后端不存在"},
+		{name: "使用默认后端", conf: `{"token":"sk","fallbacks":[{"model":"gpt-4","backend":"default"}]}`},
+	}
+	helper := NewConfHelper("config.json")
+	for _, c := range cases {
+		raw, err := helper.parseConf([]byte(c.conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = raw.Validate()
+		if c.want == "" && err != nil || c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+	conf := &ChatGptConf{Model: "gpt-4", ModelProfiles: map[string]*ModelProfile{"g": nil}}
+	if profile := conf.GetModelProfile("g"); profile.Model != "gpt-4" {
+		t.Fatalf("model = %s", profile.Model)
+	}
+}
+
+func TestMetricGroupLabel(t *testing.T) {
+	conf := &ChatGptConf{GroupNameWhiteList: []string{"a"}}
+	conf.buildIndex()
+	for groupName, want := range map[string]string{"": "", "a": "a", "b": metricGroupOther} {
+		if got := metricGroupLabel(conf, groupName); got != want {
+			t.Errorf("metricGroupLabel(%q) = %q, want %q", groupName, got, want)
+		}
+	}
+	empty := &ChatGptConf{}
+	empty.buildIndex()
+	if got := metricGroupLabel(empty, "a"); got != metricGroupOther {
+		t.Errorf("白名单为空时 metricGroupLabel = %q, want other", got)
+	}
+}
+
+// TestMatchGroupMessageMetricsByBot 被过滤的消息按账号分别计数
+func TestMatchGroupMessageMetricsByBot(t *testing.T) {
+	helper := NewTestConfHelper()
+	if _, err := helper.Update(func(conf *ChatGptConf) {
+		conf.GroupChatPrefix = []string{"@bot"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(metricMessagesFiltered.WithLabelValues("bot-b", "prefix"))
+	if match, _ := helper.MatchGroupMessage("bot-a", "g", "hello"); match {
+		t.Fatal("没有前缀的消息应被过滤")
+	}
+	if got := testutil.ToFloat64(metricMessagesFiltered.WithLabelValues("bot-a", "prefix")); got != 1 {
+		t.Fatalf("bot-a filtered = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metricMessagesFiltered.WithLabelValues("bot-b", "prefix")); got != before {
+		t.Fatalf("bot-b filtered = %v, want %v", got, before)
+	}
+}
+
+func TestChatGptConfIsAdmin(t *testing.T) {
+	conf := &ChatGptConf{Owner: "owner", Admins: []string{"", "admin"}}
+	for name, want := range map[string]bool{"owner": true, "admin": true, "": false, "other": false} {
+		if got := conf.IsAdmin(name); got != want {
+			t.Errorf("IsAdmin(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if (&ChatGptConf{}).IsAdmin("") {
+		t.Error("未配置管理员时任何好友都不是管理员")
+	}
+}
+
+func TestIsAdminFriend(t *testing.T) {
+	conf := &ChatGptConf{Owner: "张三", Admins: []string{"李四"}}
+	friends := openwechat.Friends{
+		{User: &openwechat.User{UserName: "@owner", NickName: "zhangsan", RemarkName: "张三"}},
+		{User: &openwechat.User{UserName: "@admin", NickName: "李四"}},
+		{User: &openwechat.User{UserName: "@other", NickName: "王五"}},
+	}
+	for userName, want := range map[string]bool{
+		"@owner": true,
+		"@admin": true,
+		"@other": false,
+		// 群聊中昵称为"李四"但不是好友的成员
+		"@stranger": false,
+	} {
+		if got := isAdminFriend(conf, friends, userName); got != want {
+			t.Errorf("isAdminFriend(%s) = %v, want %v", userName, got, want)
+		}
+	}
+	if isAdminFriend(&ChatGptConf{}, friends, "@admin") {
+		t.Error("未配置管理员时任何好友都不是管理员")
+	}
+}
+
+// TestUpdateAndSaveKeepsConfOnWriteFailure 配置文件写入失败时不发布新的配置
+func TestUpdateAndSaveKeepsConfOnWriteFailure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"token":"sk-test","character_desc":"v1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewConfHelper(file)
+	if _, err := helper.LoadConf(); err != nil {
+		t.Fatal(err)
+	}
+	// 配置文件替换为非空目录, 重命名临时文件会失败
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(file, "blocked"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := helper.UpdateAndSave(func(conf *ChatGptConf) { conf.CharacterDesc = "v2" }, "test", "prompt set"); err == nil {
+		t.Fatal("写入失败时应返回错误")
+	}
+	if got := helper.GetConf().CharacterDesc; got != "v1" {
+		t.Fatalf("写入失败后 character_desc = %s, want v1", got)
+	}
+}
+
+// TestUpdateAndSaveConcurrentHistory 并发修改时每个历史版本的操作与内容一一对应
+func TestUpdateAndSaveConcurrentHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"token":"sk-test"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := NewConfHelper(file)
+	if _, err := helper.LoadConf(); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 8
+	wg := sync.WaitGroup{}
+	for idx := 0; idx < writers; idx++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := helper.UpdateAndSave(func(conf *ChatGptConf) { conf.AddGroupNameWhiteList(name) },
+				"test", "group add "+name); err != nil {
+				t.Error(err)
+			}
+		}("g" + strconv.Itoa(idx))
+	}
+	wg.Wait()
+
+	entries, err := helper.History().List()
+	if err != nil || len(entries) != writers+1 {
+		t.Fatalf("history = %d, %v, want %d", len(entries), err, writers+1)
+	}
+	for _, entry := range entries {
+		if entry.Action == "initial" {
+			continue
+		}
+		conf := &ChatGptConf{}
+		if err := unmarshalConf(ConfFormatJson, []byte(entry.Content), conf); err != nil {
+			t.Fatal(err)
+		}
+		last := conf.GroupNameWhiteList[len(conf.GroupNameWhiteList)-1]
+		if entry.Action != "group add "+last {
+			t.Fatalf("v%d action = %s, 但最后添加的群聊为 %s", entry.Version, entry.Action, last)
+		}
+	}
+	if got := len(helper.GetConf().GroupNameWhiteList); got != writers {
+		t.Fatalf("group_name_white_list = %d, want %d", got, writers)
+	}
+}
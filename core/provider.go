@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	ProviderTypeOpenAI = "openai"
+	ProviderTypeAzure  = "azure"
+
+	defaultAzureAPIVersion = "2023-03-15-preview"
+)
+
+// ProviderConf 模型服务提供方配置
+type ProviderConf struct {
+	// Type 提供方类型, openai(默认) 或 azure
+	Type string `json:"type,omitempty"`
+	// BaseURL 自定义接口地址, 用于兼容OpenAI接口的代理或私有部署
+	BaseURL string `json:"base_url,omitempty"`
+	// Proxy HTTP代理地址
+	Proxy string `json:"proxy,omitempty"`
+	OrgID string `json:"org_id,omitempty"`
+
+	// AzureEndpoint Azure OpenAI 资源地址, 例如 https://xxx.openai.azure.com
+	AzureEndpoint   string `json:"azure_endpoint,omitempty"`
+	AzureAPIVersion string `json:"azure_api_version,omitempty"`
+	// AzureDeployments 模型名到部署名的映射, 未配置的模型使用去掉"."的模型名作为部署名
+	AzureDeployments map[string]string `json:"azure_deployments,omitempty"`
+}
+
+// IsAzure 是否为 Azure OpenAI
+func (p *ProviderConf) IsAzure() bool {
+	return p != nil && p.Type == ProviderTypeAzure
+}
+
+// Validate 校验提供方配置
+func (p *ProviderConf) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Type {
+	case "", ProviderTypeOpenAI:
+		if p.AzureEndpoint != "" {
+			return errors.New("provider.azure_endpoint 仅在 provider.type 为 azure 时生效")
+		}
+	case ProviderTypeAzure:
+		if p.AzureEndpoint == "" {
+			return errors.New("provider.type 为 azure 时必须配置 provider.azure_endpoint")
+		}
+		if err := validateHttpURL("provider.azure_endpoint", p.AzureEndpoint); err != nil {
+			return err
+		}
+		if p.BaseURL != "" {
+			return errors.New("provider.type 为 azure 时请使用 provider.azure_endpoint 代替 provider.base_url")
+		}
+	default:
+		return fmt.Errorf("不支持的 provider.type: %s", p.Type)
+	}
+	if err := validateHttpURL("provider.base_url", p.BaseURL); err != nil {
+		return err
+	}
+	return validateHttpURL("provider.proxy", p.Proxy)
+}
+
+// validateHttpURL 校验可选的http(s)地址
+func validateHttpURL(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return errors.Wrapf(err, "%s 格式错误", name)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s 必须是 http(s) 地址: %s", name, value)
+	}
+	return nil
+}
+
+// newOpenAIClient 按提供方配置创建单个密钥的客户端, 密钥上的 base_url 与 org_id 优先
+func newOpenAIClient(provider *ProviderConf, keyConf *KeyConf) (*openai.Client, error) {
+	if provider == nil {
+		provider = &ProviderConf{}
+	}
+	clientConf := openai.DefaultConfig(keyConf.Token)
+	clientConf.OrgID = provider.OrgID
+	if provider.BaseURL != "" {
+		clientConf.BaseURL = strings.TrimRight(provider.BaseURL, "/")
+	}
+	if keyConf.BaseURL != "" {
+		clientConf.BaseURL = strings.TrimRight(keyConf.BaseURL, "/")
+	}
+	if keyConf.OrgID != "" {
+		clientConf.OrgID = keyConf.OrgID
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if provider.Proxy != "" {
+		proxyURL, err := url.Parse(provider.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "provider.proxy 格式错误")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	clientConf.HTTPClient = &http.Client{Transport: transport}
+
+	if provider.IsAzure() {
+		endpoint := strings.TrimRight(provider.AzureEndpoint, "/")
+		if keyConf.BaseURL != "" {
+			endpoint = strings.TrimRight(keyConf.BaseURL, "/")
+		}
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.Wrap(err, "provider.azure_endpoint 格式错误")
+		}
+		apiVersion := provider.AzureAPIVersion
+		if apiVersion == "" {
+			apiVersion = defaultAzureAPIVersion
+		}
+		clientConf.BaseURL = endpoint
+		clientConf.HTTPClient.Transport = &azureTransport{
+			base:        transport,
+			basePath:    endpointURL.Path,
+			apiVersion:  apiVersion,
+			deployments: provider.AzureDeployments,
+		}
+	}
+	return openai.NewClientWithConfig(clientConf), nil
+}
+
+// azureTransport 将OpenAI格式的请求改写为 Azure OpenAI 格式
+// /chat/completions => /openai/deployments/{deployment}/chat/completions?api-version=xxx
+// 鉴权头 Authorization: Bearer xxx => api-key: xxx
+type azureTransport struct {
+	base        http.RoundTripper
+	basePath    string
+	apiVersion  string
+	deployments map[string]string
+}
+
+// RoundTrip 实现 http.RoundTripper 接口, 只修改复制的请求, 不改动调用方的 req
+func (t *azureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	model := ""
+	azureReq := req.Clone(req.Context())
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var payload struct {
+			Model string `json:"model"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		model = payload.Model
+		azureReq.Body = io.NopCloser(bytes.NewReader(body))
+		azureReq.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		azureReq.ContentLength = int64(len(body))
+	}
+
+	suffix := strings.TrimPrefix(req.URL.Path, t.basePath)
+	azureReq.URL.Path = t.basePath + "/openai/deployments/" + url.PathEscape(t.deployment(model)) + suffix
+	query := azureReq.URL.Query()
+	query.Set("api-version", t.apiVersion)
+	azureReq.URL.RawQuery = query.Encode()
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	azureReq.Header.Del("Authorization")
+	azureReq.Header.Set("api-key", token)
+	return t.base.RoundTrip(azureReq)
+}
+
+// deployment 获取模型对应的部署名
+func (t *azureTransport) deployment(model string) string {
+	if deployment, ok := t.deployments[model]; ok {
+		return deployment
+	}
+	return strings.ReplaceAll(model, ".", "")
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordedRequest 测试服务收到的请求
+type recordedRequest struct {
+	host, path, apiVersion     string
+	authorization, apiKey, org string
+}
+
+// newProviderTestServer 创建模拟模型服务, 记录收到的请求并返回固定的回复
+func newProviderTestServer(t *testing.T, useTLS bool) (*httptest.Server, *recordedRequest) {
+	recorded := &recordedRequest{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*recorded = recordedRequest{
+			host:          r.Host,
+			path:          r.URL.Path,
+			apiVersion:    r.URL.Query().Get("api-version"),
+			authorization: r.Header.Get("Authorization"),
+			apiKey:        r.Header.Get("api-key"),
+			org:           r.Header.Get("OpenAI-Organization"),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"test","object":"chat.completion","model":"gpt-3.5-turbo",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	})
+	var server *httptest.Server
+	if useTLS {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	return server, recorded
+}
+
+func createTestCompletion(t *testing.T, provider *ProviderConf, keyConf *KeyConf, model string) {
+	client, err := newOpenAIClient(provider, keyConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Choices[0].Message.Content != "ok" {
+		t.Fatalf("content = %s", resp.Choices[0].Message.Content)
+	}
+}
+
+func TestOpenAIClientDefaultProvider(t *testing.T) {
+	server, recorded := newProviderTestServer(t, true)
+	// 默认地址 api.openai.com 的连接改为连接测试服务
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	createTestCompletion(t, nil, &KeyConf{Token: "sk-default"}, openai.GPT3Dot5Turbo)
+	if recorded.host != "api.openai.com" || recorded.path != "/v1/chat/completions" {
+		t.Fatalf("request = %s%s, want api.openai.com/v1/chat/completions", recorded.host, recorded.path)
+	}
+	if recorded.authorization != "Bearer sk-default" || recorded.apiKey != "" {
+		t.Fatalf("Authorization = %q, api-key = %q", recorded.authorization, recorded.apiKey)
+	}
+}
+
+func TestOpenAIClientCustomBaseURL(t *testing.T) {
+	server, recorded := newProviderTestServer(t, false)
+	provider := &ProviderConf{BaseURL: server.URL + "/proxy/v1/", OrgID: "org-provider"}
+
+	createTestCompletion(t, provider, &KeyConf{Token: "sk-custom"}, openai.GPT3Dot5Turbo)
+	if recorded.path != "/proxy/v1/chat/completions" || recorded.org != "org-provider" {
+		t.Fatalf("path = %s, org = %s", recorded.path, recorded.org)
+	}
+	if recorded.authorization != "Bearer sk-custom" {
+		t.Fatalf("Authorization = %q", recorded.authorization)
+	}
+
+	// 密钥上的 base_url 与 org_id 优先于提供方配置
+	createTestCompletion(t, provider, &KeyConf{Token: "sk-key", BaseURL: server.URL + "/key/v1", OrgID: "org-key"}, openai.GPT3Dot5Turbo)
+	if recorded.path != "/key/v1/chat/completions" || recorded.org != "org-key" {
+		t.Fatalf("path = %s, org = %s", recorded.path, recorded.org)
+	}
+}
+
+func TestOpenAIClientAzure(t *testing.T) {
+	server, recorded := newProviderTestServer(t, false)
+	provider := &ProviderConf{
+		Type:             ProviderTypeAzure,
+		AzureEndpoint:    server.URL + "/azure/",
+		AzureDeployments: map[string]string{openai.GPT4: "gpt4-prod"},
+	}
+
+	createTestCompletion(t, provider, &KeyConf{Token: "azure-key"}, openai.GPT4)
+	if recorded.path != "/azure/openai/deployments/gpt4-prod/chat/completions" {
+		t.Fatalf("path = %s", recorded.path)
+	}
+	if recorded.apiVersion != defaultAzureAPIVersion {
+		t.Fatalf("api-version = %s", recorded.apiVersion)
+	}
+	if recorded.apiKey != "azure-key" || recorded.authorization != "" {
+		t.Fatalf("api-key = %q, Authorization = %q", recorded.apiKey, recorded.authorization)
+	}
+
+	// 未配置部署名的模型去掉"."作为部署名, 并使用配置的 api-version
+	provider.AzureAPIVersion = "2024-02-01"
+	createTestCompletion(t, provider, &KeyConf{Token: "azure-key"}, openai.GPT3Dot5Turbo)
+	if recorded.path != "/azure/openai/deployments/gpt-35-turbo/chat/completions" || recorded.apiVersion != "2024-02-01" {
+		t.Fatalf("path = %s, api-version = %s", recorded.path, recorded.apiVersion)
+	}
+}
+
+// roundTripperFunc 把函数用作 http.RoundTripper
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestAzureTransportKeepsCallerRequest 改写只作用于复制的请求, 调用方的 req 保持不变
+func TestAzureTransportKeepsCallerRequest(t *testing.T) {
+	var sent *http.Request
+	var sentBody []byte
+	transport := &azureTransport{
+		base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req
+			sentBody, _ = io.ReadAll(req.Body)
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}),
+		basePath:    "/azure",
+		apiVersion:  defaultAzureAPIVersion,
+		deployments: map[string]string{openai.GPT4: "gpt4-prod"},
+	}
+	payload := `{"model":"gpt-4"}`
+	req := httptest.NewRequest(http.MethodPost, "http://azure.local/azure/chat/completions", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer azure-key")
+	body := req.Body
+
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if sent == req || string(sentBody) != payload || sent.ContentLength != int64(len(payload)) {
+		t.Fatalf("sent = %p, body = %s, length = %d", sent, sentBody, sent.ContentLength)
+	}
+	if sent.URL.Path != "/azure/openai/deployments/gpt4-prod/chat/completions" || sent.Header.Get("api-key") != "azure-key" {
+		t.Fatalf("path = %s, api-key = %s", sent.URL.Path, sent.Header.Get("api-key"))
+	}
+	if req.Body != body || req.URL.Path != "/azure/chat/completions" || req.URL.RawQuery != "" ||
+		req.Header.Get("Authorization") != "Bearer azure-key" || req.Header.Get("api-key") != "" {
+		t.Fatalf("调用方的请求被修改: %s?%s %v", req.URL.Path, req.URL.RawQuery, req.Header)
+	}
+}
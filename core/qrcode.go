@@ -0,0 +1,64 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"github.com/skip2/go-qrcode"
+	"strings"
+)
+
+// loginQrcodePrefix 微信登录二维码的内容前缀, 扫码时识别的是该地址而不是二维码图片地址
+const loginQrcodePrefix = "https://login.weixin.qq.com/l/"
+
+// loginQrcodePngSize 登录二维码PNG图片的边长
+const loginQrcodePngSize = 256
+
+// LoginQrcodeContent 获取登录二维码的内容
+func LoginQrcodeContent(uuid string) string {
+	return loginQrcodePrefix + uuid
+}
+
+// RenderQrcodeTerminal 使用Unicode半块字符把二维码渲染为文本, 每行字符表示两行模块
+// 浅色模块绘制为方块, 适用于深色背景的终端
+func RenderQrcodeTerminal(content string) (string, error) {
+	code, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", errors.Wrap(err, "生成二维码失败")
+	}
+	bitmap := code.Bitmap()
+	light := func(y, x int) bool {
+		return y >= len(bitmap) || !bitmap[y][x]
+	}
+
+	var sb strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top, bottom := light(y, x), light(y+1, x)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// EncodeQrcodePng 生成二维码PNG图片
+func EncodeQrcodePng(content string) ([]byte, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, loginQrcodePngSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "生成二维码失败")
+	}
+	return png, nil
+}
+
+// SaveQrcodePng 保存二维码PNG图片到文件
+func SaveQrcodePng(png []byte, file string) error {
+	return writeFileAtomic(file, png, 0600)
+}
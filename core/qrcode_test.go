@@ -0,0 +1,56 @@
+package core
+
+import (
+	"bytes"
+	"github.com/skip2/go-qrcode"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderQrcodeTerminal(t *testing.T) {
+	content := LoginQrcodeContent("u1")
+	text, err := RenderQrcodeTerminal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := code.Bitmap()
+
+	// 每行字符表示两行模块, 还原后应与二维码一致, 深色模块为空格
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) != (len(bitmap)+1)/2 {
+		t.Fatalf("lines = %d, want %d", len(lines), (len(bitmap)+1)/2)
+	}
+	for row, line := range lines {
+		chars := []rune(line)
+		if len(chars) != len(bitmap[0]) {
+			t.Fatalf("第%d行宽度 = %d, want %d", row, len(chars), len(bitmap[0]))
+		}
+		for x, char := range chars {
+			top := !bitmap[2*row][x]
+			bottom := 2*row+1 >= len(bitmap) || !bitmap[2*row+1][x]
+			want := map[[2]bool]rune{{true, true}: '█', {true, false}: '▀', {false, true}: '▄', {false, false}: ' '}[[2]bool{top, bottom}]
+			if char != want {
+				t.Fatalf("(%d,%d) = %q, want %q", row, x, char, want)
+			}
+		}
+	}
+}
+
+func TestEncodeQrcodePng(t *testing.T) {
+	data, err := EncodeQrcodePng(LoginQrcodeContent("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != loginQrcodePngSize || bounds.Dy() != loginQrcodePngSize {
+		t.Fatalf("size = %v, want %d", bounds, loginQrcodePngSize)
+	}
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var ReplayCommand = &cobra.Command{
+	Use:   "replay <events.jsonl>",
+	Short: "回放事件日志中的消息, 比较当前配置下的回复与原回复",
+	Long: fmt.Sprintf("按当前的过滤规则、命令、对话上下文与提示词重新处理事件日志中的文本消息, 输出新旧回复的差异\n" +
+		"reload 与 admin 命令有副作用, 回放时跳过"),
+	Args: cobra.ExactArgs(1),
+	RunE: replayEvents,
+}
+
+var (
+	replayModel  string
+	replayStub   bool
+	replayOutput string
+)
+
+func init() {
+	ReplayCommand.Flags().StringVarP(&configFile, "configFile", "c", "chatgpt.json", "-c chatgpt.json")
+	ReplayCommand.Flags().StringVarP(&replayModel, "model", "m", "", "--model gpt-4 替换所有群聊使用的模型, 不修改配置文件")
+	ReplayCommand.Flags().BoolVar(&replayStub, "stub", false, "--stub 不请求模型, 使用事件日志中记录的模型结果")
+	ReplayCommand.Flags().StringVarP(&replayOutput, "output", "o", "", "-o replay.diff 差异写入文件, 默认输出到标准输出")
+}
+
+// replayResult 回放结果统计
+type replayResult struct {
+	Changed   int
+	Unchanged int
+	Skipped   int
+	Filtered  int
+	Errors    int
+}
+
+func replayEvents(cmd *cobra.Command, args []string) error {
+	records, err := ReadEventRecords(args[0])
+	if err != nil {
+		return errors.WithMessage(err, "读取事件日志失败")
+	}
+	instance, err := NewBotInstance(DefaultBotName, configFile, "", EnvPrefix)
+	if err != nil {
+		return err
+	}
+	if replayModel != "" {
+		if _, err := instance.confHelper.Update(func(conf *ChatGptConf) {
+			// 清空备用模型, 避免请求失败时回退到其他模型
+			conf.Model, conf.Fallbacks = replayModel, nil
+			for _, profile := range conf.ModelProfiles {
+				profile.Model, profile.Fallbacks = replayModel, nil
+			}
+		}); err != nil {
+			return errors.WithMessage(err, "替换模型失败")
+		}
+	}
+	stub := &recordedCompleter{}
+	if replayStub {
+		instance.handler.completer = stub
+	}
+
+	out := cmd.OutOrStdout()
+	if replayOutput != "" {
+		file, err := os.Create(replayOutput)
+		if err != nil {
+			return errors.Wrap(err, "创建输出文件失败")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	result := &replayResult{}
+	for idx, record := range records {
+		stub.record = record
+		instance.handler.replay(out, idx+1, record, result)
+	}
+	fmt.Fprintf(out, "共 %d 条: 变化 %d, 不变 %d, 跳过 %d, 过滤 %d, 失败 %d\n", len(records),
+		result.Changed, result.Unchanged, result.Skipped, result.Filtered, result.Errors)
+	return nil
+}
+
+// replay 重新处理一条消息并输出与原回复的差异, 不发送任何微信消息
+func (h MessageHandler) replay(out io.Writer, idx int, record *EventRecord, result *replayResult) {
+	if record.MsgType != "" && record.MsgType != openwechat.MsgTypeText.String() {
+		result.Skipped++
+		return
+	}
+	fmt.Fprintf(out, "#%d %s", idx, record.Time.Format("2006-01-02 15:04:05"))
+	if record.Group != "" {
+		fmt.Fprintf(out, " [%s]", record.Group)
+	}
+	fmt.Fprintf(out, " %s\n> %s\n", record.Sender, record.Content)
+
+	isGroupMessage := record.Group != ""
+	if isGroupMessage {
+		if match, reason := h.confHelper.MatchGroupMessage(h.instance.Name, record.Group, record.Content); !match {
+			result.Filtered++
+			fmt.Fprintf(out, "过滤: %s\n\n", strings.TrimSpace(reason))
+			return
+		}
+	}
+	msgContent := h.extractMsgContent(isGroupMessage, record.Content)
+
+	var reply string
+	switch {
+	case msgContent == "ping":
+		reply = "pong"
+	case msgContent == "context":
+		reply = h.chatContext.GetString(record.Sender)
+	case msgContent == "reload" || strings.HasPrefix(msgContent, "admin"):
+		result.Skipped++
+		fmt.Fprint(out, "跳过: 管理命令\n\n")
+		return
+	default:
+		// 以录制时间推进对话上下文, 过期判断与原对话一致
+		now := record.Time
+		if now.IsZero() {
+			now = time.Now()
+		}
+		responseBody, model, _, err := h.completeText(record.MsgType, record.Sender, record.Group, msgContent,
+			&EventRecord{}, now)
+		if errors.Is(err, ErrCircuitOpen) {
+			responseBody, err = h.confHelper.GetConf().GetMaintenanceMessage(), nil
+		}
+		if err != nil {
+			result.Errors++
+			fmt.Fprintf(out, "失败: %s\n\n", err.Error())
+			return
+		}
+		reply = strings.TrimSpace(responseBody)
+		if isGroupMessage {
+			reply = fmt.Sprintf("@%s %s", senderNickName(record.Sender), reply)
+		}
+		if h.confHelper.GetConf().ShowModel && model != "" {
+			reply += fmt.Sprintf("\n[%s]", model)
+		}
+	}
+
+	if reply == record.Reply {
+		result.Unchanged++
+		fmt.Fprint(out, "回复不变\n\n")
+		return
+	}
+	result.Changed++
+	fmt.Fprintf(out, "%s\n\n", strings.TrimRight(DiffLines(record.Reply, reply), "\n"))
+}
+
+// senderNickName 从 GetSenderName 生成的名称中取出昵称, 格式不符时原样返回
+func senderNickName(senderName string) string {
+	name := senderName
+	if idx := strings.Index(name, ":"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.LastIndex(name, "("); idx >= 0 && strings.HasSuffix(name, ")") {
+		name = name[:idx]
+	}
+	return name
+}
+
+// recordedCompleter 返回正在回放的消息在事件日志中录制的模型结果, 不请求模型
+type recordedCompleter struct {
+	record *EventRecord
+}
+
+// CreateChatCompletion 返回录制的结果, 没有录制时返回错误
+func (c *recordedCompleter) CreateChatCompletion(ctx context.Context, profile *ModelProfile,
+	req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, string, error) {
+	if c.record == nil || c.record.Response == nil || len(c.record.Response.Choices) == 0 {
+		return openai.ChatCompletionResponse{}, req.Model, errors.New("事件日志中没有该消息的模型结果")
+	}
+	return *c.record.Response, c.record.Model, nil
+}
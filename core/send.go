@@ -0,0 +1,437 @@
+package core
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/eatmoreapple/openwechat"
+	"github.com/pkg/errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendApiPath 主动发送消息接口的路径
+const sendApiPath = "/send"
+
+const (
+	// sendMaxBodySize 发送接口请求体的最大长度, 包含上传的图片与文件
+	sendMaxBodySize = 20 << 20
+	// defaultSendRatePerMinute 每个目标每分钟默认最多发送的消息数
+	defaultSendRatePerMinute = 10
+)
+
+// 发送目标类型, 为空时先查找好友再查找群聊
+const (
+	SendTargetFriend = "friend"
+	SendTargetGroup  = "group"
+)
+
+var (
+	ErrSendNotLoggedIn     = errors.New("微信未登录")
+	ErrSendTargetNotFound  = errors.New("未找到发送目标")
+	ErrSendTargetForbidden = errors.New("发送目标不在 send.targets 中")
+	ErrSendRateLimited     = errors.New("发送过于频繁")
+	ErrSendInvalidRequest  = errors.New("发送请求无效")
+	ErrSendFailed          = errors.New("发送失败")
+	ErrSendPartial         = errors.New("部分消息发送失败")
+)
+
+// 发送请求中的各部分, 与 multipart 表单的字段名相同
+const (
+	SendPartText  = "text"
+	SendPartImage = "image"
+	SendPartFile  = "file"
+)
+
+// SendConf 通过HTTP接口主动发送消息的配置, 可把登录的账号用作通知渠道
+type SendConf struct {
+	// Targets 允许发送的好友或群聊, 按备注名、昵称或ID匹配, 为空时不允许发送到任何目标
+	Targets []string `json:"targets,omitempty"`
+	// RatePerMinute 每个目标每分钟最多发送的消息数, 默认10
+	RatePerMinute int `json:"rate_per_minute,omitempty"`
+}
+
+// sendTarget 可以发送消息的好友或群聊
+type sendTarget interface {
+	SendText(content string) (*openwechat.SentMessage, error)
+	SendImage(file io.Reader) (*openwechat.SentMessage, error)
+	SendFile(file io.Reader) (*openwechat.SentMessage, error)
+}
+
+// resolvedTarget 解析后的发送目标
+type resolvedTarget struct {
+	sendTarget
+	// names 目标的ID、备注名与昵称, 用于匹配 send.targets
+	names []string
+}
+
+// name 目标的显示名称, 同时作为限流的键, ID每次登录都会变化
+func (t *resolvedTarget) name() string {
+	for _, name := range t.names[1:] {
+		if name != "" {
+			return name
+		}
+	}
+	return t.names[0]
+}
+
+// resolveSendTarget 按ID、备注名或昵称查找好友或群聊
+func (b *BotInstance) resolveSendTarget(to, targetType string) (*resolvedTarget, error) {
+	bot := b.bot.Load()
+	if bot == nil || !bot.Alive() {
+		return nil, ErrSendNotLoggedIn
+	}
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	if targetType == "" || targetType == SendTargetFriend {
+		friends, err := self.Friends()
+		if err != nil {
+			return nil, errors.Wrap(err, "获取好友列表失败")
+		}
+		friend := friends.GetByUsername(to)
+		if friend == nil {
+			friend = friends.GetByRemarkName(to)
+		}
+		if friend == nil {
+			friend = friends.GetByNickName(to)
+		}
+		if friend != nil {
+			return &resolvedTarget{sendTarget: friend, names: []string{friend.UserName, friend.RemarkName, friend.NickName}}, nil
+		}
+	}
+	if targetType == "" || targetType == SendTargetGroup {
+		groups, err := self.Groups()
+		if err != nil {
+			return nil, errors.Wrap(err, "获取群聊列表失败")
+		}
+		group := groups.GetByUsername(to)
+		if group == nil {
+			group = groups.GetByNickName(to)
+		}
+		if group != nil {
+			return &resolvedTarget{sendTarget: group, names: []string{group.UserName, group.RemarkName, group.NickName}}, nil
+		}
+	}
+	return nil, errors.WithMessage(ErrSendTargetNotFound, to)
+}
+
+// allowed 目标是否在 send.targets 中
+func (c *SendConf) allowed(target *resolvedTarget) bool {
+	if c == nil {
+		return false
+	}
+	for _, allowed := range c.Targets {
+		for _, name := range target.names {
+			if name != "" && name == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendRateLimiter 按目标限流, 每分钟为一个固定窗口
+type sendRateLimiter struct {
+	windows map[string]*sendRateWindow
+	mu      sync.Mutex
+}
+
+type sendRateWindow struct {
+	start time.Time
+	count int
+}
+
+func newSendRateLimiter() *sendRateLimiter {
+	return &sendRateLimiter{windows: make(map[string]*sendRateWindow)}
+}
+
+// allow 目标在当前窗口内还能发送 n 条消息时计数并返回true
+func (l *sendRateLimiter) allow(target string, n, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	window, ok := l.windows[target]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		window = &sendRateWindow{start: now}
+		l.windows[target] = window
+	}
+	if window.count+n > limit {
+		return false
+	}
+	window.count += n
+	return true
+}
+
+// SendRequest 主动发送的消息, 文本、图片与文件至少一项, 按文本、图片、文件的顺序发送
+type SendRequest struct {
+	To string `json:"to"`
+	// Type 目标类型 friend 或 group, 为空时先查找好友再查找群聊
+	Type  string   `json:"type,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Image *os.File `json:"-"`
+	File  *os.File `json:"-"`
+}
+
+// count 请求中的消息数
+func (r *SendRequest) count() int {
+	n := 0
+	for _, present := range []bool{r.Text != "", r.Image != nil, r.File != nil} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
+
+// SendPartResult 请求中一部分消息的发送结果
+type SendPartResult struct {
+	// Part 为 text、image 或 file
+	Part  string `json:"part"`
+	Error string `json:"error,omitempty"`
+}
+
+// SendResult 主动发送的结果, Parts 按文本、图片、文件的顺序列出每一部分
+type SendResult struct {
+	To    string           `json:"to"`
+	Sent  int              `json:"sent"`
+	Parts []SendPartResult `json:"parts"`
+	// Error 部分或全部消息发送失败的原因
+	Error string `json:"error,omitempty"`
+}
+
+// Send 发送消息到好友或群聊, 目标需在 send.targets 中且未超过限流
+// 某一部分发送失败时继续发送其余部分, 部分成功时返回 ErrSendPartial, 全部失败时返回 ErrSendFailed, 两者都带有各部分的结果
+func (b *BotInstance) Send(req *SendRequest) (*SendResult, error) {
+	if req.To == "" || req.count() == 0 {
+		return nil, errors.WithMessage(ErrSendInvalidRequest, "to 与 text、image、file 不能为空")
+	}
+	if req.Type != "" && req.Type != SendTargetFriend && req.Type != SendTargetGroup {
+		return nil, errors.WithMessagef(ErrSendInvalidRequest, "不支持的目标类型: %s", req.Type)
+	}
+	target, err := b.resolveSendTarget(req.To, req.Type)
+	if err != nil {
+		return nil, err
+	}
+	conf := b.Conf().Send
+	if !conf.allowed(target) {
+		return nil, errors.WithMessage(ErrSendTargetForbidden, target.name())
+	}
+	limit := defaultSendRatePerMinute
+	if conf.RatePerMinute > 0 {
+		limit = conf.RatePerMinute
+	}
+	if !b.sendLimiter.allow(target.name(), req.count(), limit) {
+		return nil, errors.WithMessagef(ErrSendRateLimited, "%s 每分钟最多 %d 条", target.name(), limit)
+	}
+
+	result, err := sendParts(target, req)
+	result.To = target.name()
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("通过发送接口发送到 %s 失败: %s", result.To, err.Error()))
+		return result, err
+	}
+	b.logger.Info(fmt.Sprintf("已通过发送接口发送 %d 条消息到: %s", result.Sent, result.To))
+	return result, nil
+}
+
+// sendParts 按文本、图片、文件的顺序发送, 记录每一部分的结果
+func sendParts(target sendTarget, req *SendRequest) (*SendResult, error) {
+	result := &SendResult{Parts: make([]SendPartResult, 0, req.count())}
+	failures := make([]string, 0)
+	send := func(part string, fn func() (*openwechat.SentMessage, error)) {
+		partResult := SendPartResult{Part: part}
+		if _, err := fn(); err != nil {
+			partResult.Error = err.Error()
+			failures = append(failures, part+": "+err.Error())
+		} else {
+			result.Sent++
+		}
+		result.Parts = append(result.Parts, partResult)
+	}
+	if req.Text != "" {
+		send(SendPartText, func() (*openwechat.SentMessage, error) { return target.SendText(req.Text) })
+	}
+	if req.Image != nil {
+		send(SendPartImage, func() (*openwechat.SentMessage, error) { return target.SendImage(req.Image) })
+	}
+	if req.File != nil {
+		send(SendPartFile, func() (*openwechat.SentMessage, error) { return target.SendFile(req.File) })
+	}
+
+	switch {
+	case len(failures) == 0:
+		return result, nil
+	case result.Sent == 0:
+		return result, errors.WithMessage(ErrSendFailed, strings.Join(failures, "; "))
+	default:
+		return result, errors.WithMessage(ErrSendPartial, strings.Join(failures, "; "))
+	}
+}
+
+// sendApiHandler 主动发送消息接口, 使用 http.send_token 或 http.admin_token 认证
+//
+//	POST /send[?bot=<name>]  JSON {"to": "", "type": "", "text": ""}
+//	                         或 multipart/form-data, 字段 to、type、text, 文件字段 image、file
+type sendApiHandler struct {
+	manager *BotManager
+}
+
+func (h sendApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	instance, ok := h.manager.Get(r.URL.Query().Get("bot"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "bot not found: "+r.URL.Query().Get("bot"))
+		return
+	}
+
+	req := &SendRequest{}
+	r.Body = http.MaxBytesReader(w, r.Body, sendMaxBodySize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		dir, err := os.MkdirTemp("", "chatgpt-bot-send-*")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer os.RemoveAll(dir)
+		defer closeSendFiles(req)
+		if err := readSendForm(r, dir, req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if !readJson(w, r, req) {
+		return
+	}
+
+	result, err := instance.Send(req)
+	writeSendResult(w, result, err)
+}
+
+// writeSendResult 返回发送结果, 部分消息发送失败时返回207与各部分的结果
+func writeSendResult(w http.ResponseWriter, result *SendResult, err error) {
+	status := sendErrorStatus(err)
+	if result == nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	writeJson(w, status, result)
+}
+
+// sendErrorStatus 发送错误对应的HTTP状态码
+func sendErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrSendPartial):
+		return http.StatusMultiStatus
+	case errors.Is(err, ErrSendNotLoggedIn):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrSendTargetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSendTargetForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrSendRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrSendInvalidRequest):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// authorize 校验 Authorization: Bearer <token>, send_token 与 admin_token 均可使用
+func (h sendApiHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	conf := h.manager.httpConf()
+	if conf == nil || (conf.SendToken == "" && conf.AdminToken == "") {
+		writeError(w, http.StatusForbidden, "send api disabled, http.send_token is not configured")
+		return false
+	}
+	token := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	for _, expected := range []string{conf.SendToken, conf.AdminToken} {
+		if expected != "" && subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			return true
+		}
+	}
+	writeError(w, http.StatusUnauthorized, "unauthorized")
+	return false
+}
+
+// readSendForm 读取 multipart 表单, 上传的文件以原文件名保存到 dir, 接收方看到的文件名与上传时一致
+func readSendForm(r *http.Request, dir string, req *SendRequest) error {
+	if err := r.ParseMultipartForm(sendMaxBodySize); err != nil {
+		return errors.Wrap(err, "解析表单失败")
+	}
+	req.To = r.FormValue("to")
+	req.Type = r.FormValue("type")
+	req.Text = r.FormValue("text")
+	var err error
+	if req.Image, err = saveSendFile(r, "image", dir); err != nil {
+		return err
+	}
+	if req.File, err = saveSendFile(r, "file", dir); err != nil {
+		return err
+	}
+	return nil
+}
+
+func saveSendFile(r *http.Request, field, dir string) (*os.File, error) {
+	headers := r.MultipartForm.File[field]
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	header := headers[0]
+	name := filepath.Base(header.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = field
+	}
+	// image 与 file 可能同名, 分别保存在子目录中
+	if err := os.Mkdir(filepath.Join(dir, field), 0700); err != nil {
+		return nil, err
+	}
+	src, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return copySendFile(src, filepath.Join(dir, field, name))
+}
+
+func copySendFile(src multipart.File, path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func closeSendFiles(req *SendRequest) {
+	for _, file := range []*os.File{req.Image, req.File} {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/eatmoreapple/openwechat"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendRateLimiter(t *testing.T) {
+	limiter := newSendRateLimiter()
+	if !limiter.allow("a", 2, 3) || limiter.allow("a", 2, 3) || !limiter.allow("a", 1, 3) {
+		t.Fatal("同一窗口内最多发送3条")
+	}
+	if !limiter.allow("b", 3, 3) {
+		t.Fatal("不同目标分别限流")
+	}
+	limiter.windows["a"].start = time.Now().Add(-time.Minute)
+	if !limiter.allow("a", 3, 3) {
+		t.Fatal("窗口结束后重新计数")
+	}
+}
+
+func TestSendConfAllowed(t *testing.T) {
+	target := &resolvedTarget{names: []string{"@id", "", "CI通知群"}}
+	var nilConf *SendConf
+	for _, c := range []struct {
+		conf *SendConf
+		want bool
+	}{
+		{nilConf, false},
+		{&SendConf{}, false},
+		{&SendConf{Targets: []string{""}}, false},
+		{&SendConf{Targets: []string{"CI通知群"}}, true},
+		{&SendConf{Targets: []string{"@id"}}, true},
+		{&SendConf{Targets: []string{"其他群"}}, false},
+	} {
+		if got := c.conf.allowed(target); got != c.want {
+			t.Errorf("allowed(%+v) = %v, want %v", c.conf, got, c.want)
+		}
+	}
+}
+
+// newTestSendHandler 创建只有一个未登录实例的发送接口
+func newTestSendHandler(httpConf *HttpConf) sendApiHandler {
+	instance := &BotInstance{Name: DefaultBotName, confHelper: NewTestConfHelper(), logger: zap.NewNop(),
+		sendLimiter: newSendRateLimiter()}
+	return sendApiHandler{manager: &BotManager{
+		instances: []*BotInstance{instance},
+		httpConf:  func() *HttpConf { return httpConf },
+	}}
+}
+
+func TestSendApiAuthorize(t *testing.T) {
+	body := `{"to":"CI通知群","text":"构建成功"}`
+	for _, c := range []struct {
+		name  string
+		conf  *HttpConf
+		token string
+		want  int
+	}{
+		{"未配置令牌", &HttpConf{}, "", http.StatusForbidden},
+		{"令牌错误", &HttpConf{SendToken: "send"}, "wrong", http.StatusUnauthorized},
+		{"send_token", &HttpConf{SendToken: "send"}, "send", http.StatusServiceUnavailable},
+		{"admin_token", &HttpConf{SendToken: "send", AdminToken: "admin"}, "admin", http.StatusServiceUnavailable},
+	} {
+		server := httptest.NewServer(newTestSendHandler(c.conf))
+		req, _ := http.NewRequest(http.MethodPost, server.URL+sendApiPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+		// 认证通过后实例未登录, 返回503
+		if resp.StatusCode != c.want {
+			t.Errorf("%s: status = %d, want %d", c.name, resp.StatusCode, c.want)
+		}
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		nil:                    http.StatusOK,
+		ErrSendNotLoggedIn:     http.StatusServiceUnavailable,
+		ErrSendTargetNotFound:  http.StatusNotFound,
+		ErrSendTargetForbidden: http.StatusForbidden,
+		ErrSendRateLimited:     http.StatusTooManyRequests,
+		ErrSendInvalidRequest:  http.StatusBadRequest,
+		ErrSendPartial:         http.StatusMultiStatus,
+		ErrSendFailed:          http.StatusBadGateway,
+		errors.New("其他错误"):     http.StatusBadGateway,
+	} {
+		if got := sendErrorStatus(err); got != want {
+			t.Errorf("sendErrorStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
+
+// fakeSendTarget 记录发送的消息, 图片发送失败
+type fakeSendTarget struct {
+	sent []string
+}
+
+func (f *fakeSendTarget) SendText(content string) (*openwechat.SentMessage, error) {
+	f.sent = append(f.sent, SendPartText)
+	return &openwechat.SentMessage{}, nil
+}
+
+func (f *fakeSendTarget) SendImage(file io.Reader) (*openwechat.SentMessage, error) {
+	return nil, errors.New("upload failed")
+}
+
+func (f *fakeSendTarget) SendFile(file io.Reader) (*openwechat.SentMessage, error) {
+	f.sent = append(f.sent, SendPartFile)
+	return &openwechat.SentMessage{}, nil
+}
+
+func TestSendPartialDelivery(t *testing.T) {
+	target := &fakeSendTarget{}
+	req := &SendRequest{To: "CI通知群", Text: "构建成功", Image: os.Stdin, File: os.Stdin}
+	result, err := sendParts(target, req)
+	if !errors.Is(err, ErrSendPartial) || result.Sent != 2 || len(target.sent) != 2 {
+		t.Fatalf("图片失败后应继续发送文件, result = %+v, err = %v", result, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	writeSendResult(recorder, result, err)
+	if recorder.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207", recorder.Code)
+	}
+	var body SendResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Parts) != 3 || body.Parts[0].Error != "" || body.Parts[1].Part != SendPartImage ||
+		body.Parts[1].Error == "" || body.Parts[2].Error != "" || body.Error == "" {
+		t.Fatalf("body = %+v", body)
+	}
+
+	result, err = sendParts(target, &SendRequest{To: "CI通知群", Image: os.Stdin})
+	if !errors.Is(err, ErrSendFailed) || result.Sent != 0 || sendErrorStatus(err) != http.StatusBadGateway {
+		t.Fatalf("全部失败时应返回 ErrSendFailed, result = %+v, err = %v", result, err)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	defaultReloginMinSeconds = 5
+	defaultReloginMaxSeconds = 300
+	// reloginStableDuration 在线超过该时长后掉线, 重新登录的等待时间从初始值开始计算
+	reloginStableDuration = 10 * time.Minute
+)
+
+// errSessionExpired 微信会话失效, openwechat 在会话过期或在手机上退出登录时退出, 但不一定返回原因
+var errSessionExpired = errors.New("微信会话已失效")
+
+// supervise 守护微信登录, 掉线或登录失败后按退避时间重新登录, 通过管理接口主动退出时返回
+// 每次登录都创建新的 openwechat.Bot, 对话上下文与配置保存在实例中, 重连后保持不变
+func (b *BotInstance) supervise() {
+	delay := time.Duration(0)
+	for {
+		if delay > 0 {
+			b.logger.Info(fmt.Sprintf("%s后重新登录微信", delay))
+			time.Sleep(delay)
+		}
+
+		storage, err := NewLoginStorage(b.Conf(), b.loginStorageFile)
+		if err != nil {
+			metricErrors.WithLabelValues(b.Name, errorCategoryLogin).Inc()
+			b.logger.Error("创建登录信息存储失败: " + err.Error())
+			delay = b.nextReloginDelay(delay)
+			continue
+		}
+		bot, err := b.buildWechatBotService(storage)
+		if err != nil {
+			metricErrors.WithLabelValues(b.Name, errorCategoryLogin).Inc()
+			b.logger.Error("微信登录失败: " + err.Error())
+			delay = b.nextReloginDelay(delay)
+			continue
+		}
+
+		loginAt := time.Now()
+		stop, reason := b.logoutReason(b.serve(bot))
+		b.onWechatLogout(reason)
+		if stop {
+			b.logger.Info("已主动退出登录, 不再自动重新登录")
+			return
+		}
+
+		if time.Since(loginAt) > reloginStableDuration {
+			delay = 0
+		}
+		delay = b.nextReloginDelay(delay)
+	}
+}
+
+// logoutReason 判断微信退出后是否停止守护, 只有通过 logout 主动退出时停止
+// 其他情况都按掉线处理, 没有返回原因时视为会话失效, 继续重新登录并发送掉线通知
+func (b *BotInstance) logoutReason(reason error) (bool, error) {
+	if b.loggedOut.Load() {
+		return true, reason
+	}
+	if reason == nil {
+		reason = errSessionExpired
+	}
+	return false, reason
+}
+
+// logout 主动退出微信登录, 退出后该账号不再自动重新登录
+func (b *BotInstance) logout() error {
+	bot := b.bot.Load()
+	if bot == nil || !bot.Alive() {
+		return errors.New("微信未登录")
+	}
+	b.loggedOut.Store(true)
+	if err := bot.Logout(); err != nil {
+		b.loggedOut.Store(false)
+		return errors.Wrap(err, "退出微信登录失败")
+	}
+	return nil
+}
+
+// nextReloginDelay 计算下一次重新登录的等待时间, 从 relogin_min_seconds 开始翻倍, 不超过 relogin_max_seconds
+func (b *BotInstance) nextReloginDelay(delay time.Duration) time.Duration {
+	minDelay, maxDelay := defaultReloginMinSeconds*time.Second, defaultReloginMaxSeconds*time.Second
+	if conf := b.Conf().Login; conf != nil {
+		if conf.ReloginMinSeconds > 0 {
+			minDelay = time.Duration(conf.ReloginMinSeconds) * time.Second
+		}
+		if conf.ReloginMaxSeconds > 0 {
+			maxDelay = time.Duration(conf.ReloginMaxSeconds) * time.Second
+		}
+	}
+	delay *= 2
+	if delay < minDelay {
+		delay = minDelay
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// onWechatLogout 微信掉线或退出时记录日志并发送掉线通知
+func (b *BotInstance) onWechatLogout(reason error) {
+	text := "主动退出登录"
+	if reason != nil {
+		text = reason.Error()
+	}
+	b.logger.Warn("微信已退出: " + text)
+	b.reconnecting.Store(reason != nil)
+	b.emitEvent(WebhookEventLogout, &WebhookLoginData{Reason: text})
+	b.notifyLoginEvent(&LoginEvent{
+		Event:  LoginEventLogout,
+		Reason: text,
+		Time:   time.Now(),
+	})
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestLogoutReasonKeepsRelogin openwechat 在会话失效时退出但不返回原因, 只有主动退出才停止守护
+func TestLogoutReasonKeepsRelogin(t *testing.T) {
+	instance := &BotInstance{Name: "test"}
+	stop, reason := instance.logoutReason(nil)
+	if stop || !errors.Is(reason, errSessionExpired) {
+		t.Fatalf("没有原因的退出应重新登录, stop = %v, reason = %v", stop, reason)
+	}
+	crash := errors.New("sync check failed")
+	if stop, reason := instance.logoutReason(crash); stop || reason != crash {
+		t.Fatalf("掉线应重新登录, stop = %v, reason = %v", stop, reason)
+	}
+
+	if err := instance.logout(); err == nil || instance.loggedOut.Load() {
+		t.Fatalf("未登录时不能主动退出, err = %v", err)
+	}
+	instance.loggedOut.Store(true)
+	if stop, reason := instance.logoutReason(nil); !stop || reason != nil {
+		t.Fatalf("主动退出后应停止守护, stop = %v, reason = %v", stop, reason)
+	}
+}
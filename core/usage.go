@@ -0,0 +1,88 @@
+package core
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"sync"
+)
+
+// UsageStat 用量统计
+type UsageStat struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (s *UsageStat) add(usage openai.Usage) {
+	s.Requests++
+	s.PromptTokens += usage.PromptTokens
+	s.CompletionTokens += usage.CompletionTokens
+}
+
+// UsageSnapshot 用量统计快照
+type UsageSnapshot struct {
+	Total    UsageStat             `json:"total"`
+	BySender map[string]*UsageStat `json:"by_sender"`
+	ByGroup  map[string]*UsageStat `json:"by_group"`
+	ByModel  map[string]*UsageStat `json:"by_model"`
+}
+
+// UsageTracker 按发送者、群聊、模型统计模型用量, 进程重启后清零
+type UsageTracker struct {
+	total    UsageStat
+	bySender map[string]*UsageStat
+	byGroup  map[string]*UsageStat
+	byModel  map[string]*UsageStat
+	mu       sync.Mutex
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{
+		bySender: make(map[string]*UsageStat),
+		byGroup:  make(map[string]*UsageStat),
+		byModel:  make(map[string]*UsageStat),
+	}
+}
+
+// Record 记录一次模型请求的用量, 私聊的 groupName 为空
+func (u *UsageTracker) Record(senderName, groupName, model string, usage openai.Usage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.total.add(usage)
+	usageStatOf(u.bySender, senderName).add(usage)
+	if groupName != "" {
+		usageStatOf(u.byGroup, groupName).add(usage)
+	}
+	usageStatOf(u.byModel, model).add(usage)
+}
+
+// Snapshot 获取用量统计快照
+func (u *UsageTracker) Snapshot() UsageSnapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return UsageSnapshot{
+		Total:    u.total,
+		BySender: copyUsageStats(u.bySender),
+		ByGroup:  copyUsageStats(u.byGroup),
+		ByModel:  copyUsageStats(u.byModel),
+	}
+}
+
+func usageStatOf(stats map[string]*UsageStat, key string) *UsageStat {
+	stat, ok := stats[key]
+	if !ok {
+		stat = &UsageStat{}
+		stats[key] = stat
+	}
+	return stat
+}
+
+func copyUsageStats(stats map[string]*UsageStat) map[string]*UsageStat {
+	result := make(map[string]*UsageStat, len(stats))
+	for key, stat := range stats {
+		statCopy := *stat
+		result[key] = &statCopy
+	}
+	return result
+}
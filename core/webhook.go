@@ -0,0 +1,394 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	WebhookEventMessageReceived = "message_received"
+	WebhookEventReplySent       = "reply_sent"
+	WebhookEventAdminCommand    = "admin_command"
+	WebhookEventLogin           = "login"
+	WebhookEventLogout          = "logout"
+	WebhookEventError           = "error"
+)
+
+var webhookEvents = map[string]bool{
+	WebhookEventMessageReceived: true,
+	WebhookEventReplySent:       true,
+	WebhookEventAdminCommand:    true,
+	WebhookEventLogin:           true,
+	WebhookEventLogout:          true,
+	WebhookEventError:           true,
+}
+
+// 签名相关的请求头, 签名为 "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+const (
+	WebhookHeaderEvent     = "X-Chatgpt-Bot-Event"
+	WebhookHeaderTimestamp = "X-Chatgpt-Bot-Timestamp"
+	WebhookHeaderSignature = "X-Chatgpt-Bot-Signature"
+)
+
+const (
+	defaultWebhookMaxRetries     = 3
+	defaultWebhookDeadLetterFile = "webhook.deadletter.jsonl"
+	webhookTimeout               = 10 * time.Second
+	webhookRetryInterval         = time.Second
+	// webhookQueueSize 等待发送的事件数上限, 超出时直接写入死信文件
+	webhookQueueSize = 256
+	// webhookWorkers 每个机器人实例发送事件的并发数
+	webhookWorkers = 4
+)
+
+// WebhookConf 外发事件配置, 事件以签名的JSON POST到每个地址
+type WebhookConf struct {
+	Endpoints []*WebhookEndpointConf `json:"endpoints,omitempty"`
+	// MaxRetries 发送失败后的重试次数, 未配置时为3次, 0为不重试, 重试间隔从1秒开始翻倍
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// DeadLetterFile 重试后仍失败的事件追加写入该文件, 每行一个JSON, 默认 webhook.deadletter.jsonl
+	DeadLetterFile string `json:"dead_letter_file,omitempty"`
+	// Redact 事件中的发送者替换为哈希值, 消息、请求与回复只保留长度; 开启日志脱敏(-r)时同样生效
+	Redact bool `json:"redact,omitempty"`
+}
+
+// WebhookEndpointConf 外发事件地址
+type WebhookEndpointConf struct {
+	Url string `json:"url"`
+	// Secret 签名密钥, 为空时不签名
+	Secret string `json:"secret,omitempty" secret:"true"`
+	// Headers 附加的请求头, 通常包含鉴权信息, 按敏感字段遮盖与加密
+	Headers map[string]string `json:"headers,omitempty" secret:"true"`
+	// Events 订阅的事件类型, 为空时订阅所有事件
+	Events []string `json:"events,omitempty"`
+}
+
+// Validate 校验外发事件配置
+func (c *WebhookConf) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return errors.New("webhook.max_retries 不能小于0")
+	}
+	for idx, endpoint := range c.Endpoints {
+		if endpoint == nil || endpoint.Url == "" {
+			return fmt.Errorf("webhook.endpoints[%d]: url 不能为空", idx)
+		}
+		if err := validateHttpURL(fmt.Sprintf("webhook.endpoints[%d].url", idx), endpoint.Url); err != nil {
+			return err
+		}
+		for key := range endpoint.Headers {
+			if webhookReservedHeaders[http.CanonicalHeaderKey(key)] {
+				return fmt.Errorf("webhook.endpoints[%d].headers: 不能覆盖请求头 %s", idx, key)
+			}
+		}
+		for _, event := range endpoint.Events {
+			if !webhookEvents[event] {
+				return fmt.Errorf("webhook.endpoints[%d]: 不支持的事件类型: %s", idx, event)
+			}
+		}
+	}
+	return nil
+}
+
+// maxRetries 发送失败后的重试次数
+func (c *WebhookConf) maxRetries() int {
+	if c.MaxRetries == nil {
+		return defaultWebhookMaxRetries
+	}
+	return *c.MaxRetries
+}
+
+// webhookReservedHeaders 由机器人设置的请求头, endpoints 的 headers 不能覆盖
+var webhookReservedHeaders = map[string]bool{
+	WebhookHeaderEvent:     true,
+	WebhookHeaderTimestamp: true,
+	WebhookHeaderSignature: true,
+}
+
+// subscribed 地址是否订阅了该事件
+func (c *WebhookEndpointConf) subscribed(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, item := range c.Events {
+		if item == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent 外发事件, Data 的内容由事件类型决定
+type WebhookEvent struct {
+	Id    string      `json:"id"`
+	Event string      `json:"event"`
+	Bot   string      `json:"bot"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// WebhookMessageData message_received 事件内容
+type WebhookMessageData struct {
+	MsgId   string `json:"msg_id"`
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Group   string `json:"group,omitempty"`
+	Content string `json:"content"`
+}
+
+// WebhookReplyData reply_sent 事件内容
+type WebhookReplyData struct {
+	MsgId            string `json:"msg_id"`
+	Sender           string `json:"sender"`
+	Group            string `json:"group,omitempty"`
+	Model            string `json:"model"`
+	Request          string `json:"request"`
+	Reply            string `json:"reply"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// WebhookAdminData admin_command 事件内容
+type WebhookAdminData struct {
+	Sender  string `json:"sender"`
+	Group   string `json:"group,omitempty"`
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WebhookLoginData login、logout 事件内容
+type WebhookLoginData struct {
+	User   string `json:"user,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// WebhookErrorData error 事件内容
+type WebhookErrorData struct {
+	MsgId  string `json:"msg_id,omitempty"`
+	Sender string `json:"sender,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Error  string `json:"error"`
+}
+
+// redactWebhookData 脱敏后的事件内容副本, 登录事件中的是机器人自己的账号, 不需要脱敏
+func redactWebhookData(data interface{}) interface{} {
+	switch d := data.(type) {
+	case *WebhookMessageData:
+		redacted := *d
+		redacted.Sender, redacted.Content = hashSender(d.Sender), summarizeBody(d.Content)
+		return &redacted
+	case *WebhookReplyData:
+		redacted := *d
+		redacted.Sender = hashSender(d.Sender)
+		redacted.Request, redacted.Reply = summarizeBody(d.Request), summarizeBody(d.Reply)
+		return &redacted
+	case *WebhookAdminData:
+		redacted := *d
+		redacted.Sender, redacted.Command = hashSender(d.Sender), summarizeBody(d.Command)
+		return &redacted
+	case *WebhookErrorData:
+		redacted := *d
+		redacted.Sender = hashSender(d.Sender)
+		return &redacted
+	}
+	return data
+}
+
+// SignWebhook 计算事件签名, 接收方使用相同的密钥、时间戳与请求体校验
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDeadLetter 死信文件中的一行
+type webhookDeadLetter struct {
+	Url      string        `json:"url"`
+	Error    string        `json:"error"`
+	Attempts int           `json:"attempts"`
+	FailedAt time.Time     `json:"failed_at"`
+	Event    *WebhookEvent `json:"event"`
+}
+
+// webhookDispatcher 发送外发事件, 事件放入有界队列后由固定数量的worker发送与重试, 不阻塞消息处理
+type webhookDispatcher struct {
+	client *http.Client
+	queue  chan func()
+	// startOnce 首次发送事件时启动worker
+	startOnce sync.Once
+}
+
+// webhookDeadLetterMu 多个机器人实例的worker可能共用死信文件, 追加写入时互斥
+var webhookDeadLetterMu sync.Mutex
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan func(), webhookQueueSize),
+	}
+}
+
+// enqueue 将发送任务放入队列, 队列已满时返回false
+func (d *webhookDispatcher) enqueue(task func()) bool {
+	d.startOnce.Do(func() {
+		for i := 0; i < webhookWorkers; i++ {
+			go func() {
+				for task := range d.queue {
+					task()
+				}
+			}()
+		}
+	})
+	select {
+	case d.queue <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventsEnabled 是否配置了外发事件, 事件内容需要额外查询时先检查
+func (b *BotInstance) eventsEnabled() bool {
+	conf := b.Conf().Webhook
+	return conf != nil && len(conf.Endpoints) > 0
+}
+
+// emitEvent 发送外发事件, 未配置 webhook 时忽略
+func (b *BotInstance) emitEvent(event string, data interface{}) {
+	if !b.eventsEnabled() {
+		return
+	}
+	conf := b.Conf().Webhook
+	if redactEnabled(conf.Redact) {
+		data = redactWebhookData(data)
+	}
+	webhookEvent := &WebhookEvent{
+		Id:    newWebhookEventId(),
+		Event: event,
+		Bot:   b.Name,
+		Time:  time.Now(),
+		Data:  data,
+	}
+	for _, endpoint := range conf.Endpoints {
+		if !endpoint.subscribed(event) {
+			continue
+		}
+		endpoint := endpoint
+		if !b.webhooks.enqueue(func() { b.deliverEvent(conf, endpoint, webhookEvent) }) {
+			b.failEvent(conf, endpoint, webhookEvent, errors.New("外发事件队列已满"), 0)
+		}
+	}
+}
+
+// deliverEvent 发送事件到一个地址, 失败时按间隔翻倍重试, 仍失败时写入死信文件
+func (b *BotInstance) deliverEvent(conf *WebhookConf, endpoint *WebhookEndpointConf, event *WebhookEvent) {
+	maxRetries := conf.maxRetries()
+	body, err := json.Marshal(event)
+	if err != nil {
+		b.logger.Warn("序列化外发事件失败: " + err.Error())
+		return
+	}
+
+	interval := webhookRetryInterval
+	attempts := 0
+	for {
+		attempts++
+		if err = b.webhooks.post(endpoint, event.Event, body); err == nil {
+			return
+		}
+		if attempts > maxRetries {
+			break
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+
+	b.failEvent(conf, endpoint, event, err, attempts)
+}
+
+// failEvent 记录发送失败的事件并写入死信文件
+func (b *BotInstance) failEvent(conf *WebhookConf, endpoint *WebhookEndpointConf, event *WebhookEvent, err error, attempts int) {
+	metricErrors.WithLabelValues(b.Name, errorCategoryWebhook).Inc()
+	b.logger.Warn(fmt.Sprintf("发送外发事件 %s 到 %s 失败: %s", event.Event, endpoint.Url, err.Error()))
+	deadLetterFile := conf.DeadLetterFile
+	if deadLetterFile == "" {
+		deadLetterFile = defaultWebhookDeadLetterFile
+	}
+	if err := b.webhooks.writeDeadLetter(deadLetterFile, &webhookDeadLetter{
+		Url:      endpoint.Url,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+		Event:    event,
+	}); err != nil {
+		b.logger.Error("写入外发事件死信文件失败: " + err.Error())
+	}
+}
+
+func (d *webhookDispatcher) post(endpoint *WebhookEndpointConf, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// 先设置附加的请求头, 事件类型、时间戳与签名不会被覆盖
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, value)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(WebhookHeaderEvent, event)
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if endpoint.Secret != "" {
+		req.Header.Set(WebhookHeaderSignature, SignWebhook(endpoint.Secret, timestamp, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *webhookDispatcher) writeDeadLetter(file string, letter *webhookDeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	webhookDeadLetterMu.Lock()
+	defer webhookDeadLetterMu.Unlock()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// newWebhookEventId 生成事件ID, 接收方可用于去重
+func newWebhookEventId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWebhookDispatcherQueueIsBounded(t *testing.T) {
+	dispatcher := newWebhookDispatcher()
+	release := make(chan struct{})
+	defer close(release)
+
+	accepted := 0
+	for accepted <= webhookQueueSize+webhookWorkers {
+		if !dispatcher.enqueue(func() { <-release }) {
+			break
+		}
+		accepted++
+	}
+	if accepted < webhookQueueSize || accepted > webhookQueueSize+webhookWorkers {
+		t.Fatalf("accepted = %d, 队列应在 %d 个任务左右时拒绝新任务", accepted, webhookQueueSize)
+	}
+}
+
+func TestRedactWebhookData(t *testing.T) {
+	data := &WebhookReplyData{Sender: "Person:a(1)", Group: "g", Request: "hello", Reply: "world"}
+	redacted := redactWebhookData(data).(*WebhookReplyData)
+	if redacted.Sender != hashSender("Person:a(1)") || redacted.Request != "***(5 chars)" || redacted.Reply != "***(5 chars)" {
+		t.Fatalf("redacted = %+v", redacted)
+	}
+	if redacted.Group != "g" || data.Request != "hello" {
+		t.Fatalf("原事件不应被修改: %+v", data)
+	}
+}
+
+func TestWebhookConfValidate(t *testing.T) {
+	zero, negative := 0, -1
+	for _, c := range []struct {
+		conf  *WebhookConf
+		valid bool
+	}{
+		{&WebhookConf{MaxRetries: &zero}, true},
+		{&WebhookConf{MaxRetries: &negative}, false},
+		{&WebhookConf{Endpoints: []*WebhookEndpointConf{{Url: "https://example.com", Headers: map[string]string{"X-Token": "t"}}}}, true},
+		{&WebhookConf{Endpoints: []*WebhookEndpointConf{{Url: "https://example.com", Headers: map[string]string{"x-chatgpt-bot-signature": "s"}}}}, false},
+	} {
+		if err := c.conf.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", c.conf, err, c.valid)
+		}
+	}
+	if got := (&WebhookConf{}).maxRetries(); got != defaultWebhookMaxRetries {
+		t.Fatalf("未配置时 maxRetries = %d, want %d", got, defaultWebhookMaxRetries)
+	}
+}
+
+func TestDeliverEventWithoutRetries(t *testing.T) {
+	var requests atomic.Int32
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		header = r.Header
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	zero := 0
+	deadLetterFile := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	conf := &WebhookConf{MaxRetries: &zero, DeadLetterFile: deadLetterFile}
+	// 绕过配置校验, 附加的请求头也不能覆盖签名
+	endpoint := &WebhookEndpointConf{Url: server.URL, Secret: "s",
+		Headers: map[string]string{"X-Token": "t", WebhookHeaderSignature: "forged"}}
+	instance := &BotInstance{Name: "test", logger: zap.NewNop(), webhooks: newWebhookDispatcher()}
+	instance.deliverEvent(conf, endpoint, &WebhookEvent{Id: "1", Event: WebhookEventError})
+
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("requests = %d, max_retries 为0时不应重试", got)
+	}
+	if header.Get("X-Token") != "t" || header.Get(WebhookHeaderSignature) == "forged" {
+		t.Fatalf("header = %v", header)
+	}
+	f, err := os.Open(deadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("失败的事件应写入死信文件")
+	}
+	var letter webhookDeadLetter
+	if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil || letter.Attempts != 1 {
+		t.Fatalf("letter = %+v, err = %v", letter, err)
+	}
+}
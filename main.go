@@ -16,6 +16,10 @@ var rootCommand = &cobra.Command{
 // main 执行真正业务逻辑
 func main() {
 	addCommand(rootCommand, core.ChatGPTCommand)
+	addCommand(rootCommand, core.ConfigCommand)
+	addCommand(rootCommand, core.ReplayCommand)
+	addCommand(rootCommand, core.EvalCommand)
+	addCommand(rootCommand, core.ExportCommand)
 	cobra.CheckErr(rootCommand.ExecuteContext(context.Background()))
 }
 
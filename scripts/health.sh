@@ -0,0 +1,17 @@
+#!/bin/bash
+
+# 检查机器人健康状态, 需要在配置中开启 http.listen
+# 用法: bash health.sh [地址] [healthz|readyz], 默认 127.0.0.1:9090 healthz
+
+ADDR=${1:-127.0.0.1:9090}
+CHECK=${2:-healthz}
+
+RESULT=$(curl -s -w "\n%{http_code}" "http://${ADDR}/${CHECK}")
+CODE=$(echo "$RESULT" | tail -n 1)
+
+echo "$RESULT" | sed '$d'
+
+if [[ "$CODE" != "200" ]];then
+    exit 1
+fi
+exit 0